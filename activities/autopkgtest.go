@@ -0,0 +1,93 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"pkbldr/builds"
+	"pkbldr/config"
+	"pkbldr/repo"
+	"strings"
+
+	"github.com/docker/docker/client"
+)
+
+// autopkgtestRunner returns the virtualisation server arguments passed to
+// autopkgtest after the "--" separator.
+func autopkgtestRunner() string {
+	if config.Configs.Autopkgtest.Runner == "chroot" {
+		return "chroot " + config.Configs.Autopkgtest.Chroot
+	}
+	return "null"
+}
+
+// testedSourceDir returns the unpacked source tree in dir that ships
+// autopkgtests, if any.
+func testedSourceDir(dir string) (string, bool) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*", "debian", "tests", "control"))
+	if err != nil || len(matches) == 0 {
+		return "", false
+	}
+	return filepath.Base(filepath.Dir(filepath.Dir(filepath.Dir(matches[0])))), true
+}
+
+// autopkgtestStatus maps the autopkgtest exit code to a test status.
+func autopkgtestStatus(exitCode int) builds.TestStatus {
+	switch exitCode {
+	case 0:
+		return builds.TestsPassed
+	case 2, 8:
+		// Some or all tests skipped, or none to run at all
+		return builds.TestsSkipped
+	case 4, 6:
+		return builds.TestsFailed
+	default:
+		return builds.TestsError
+	}
+}
+
+// runAutopkgtest runs the autopkgtests of the source in dir against the
+// freshly built binaries, inside the builder container. The error is only
+// set when the tests tripped a time limit or were cancelled.
+func runAutopkgtest(ctx context.Context, cli *client.Client, containerID string, dir string, debFiles []string) (builds.TestResult, error) {
+	srcDir, ok := testedSourceDir(dir)
+	if !ok {
+		return builds.TestResult{}, nil
+	}
+
+	runner := autopkgtestRunner()
+	pkgdir := filepath.Base(dir)
+	command := "cd " + pkgdir + " && autopkgtest --output-dir autopkgtest-output ./" + strings.Join(debFiles, " ./") + " ./" + srcDir + "/ -- " + runner
+	output, exitCode, err := runLimited(ctx, cli, containerID, command, checkLimits())
+	if err != nil {
+		result := builds.TestResult{
+			Runner:   runner,
+			Status:   builds.TestsError,
+			ExitCode: -1,
+			Output:   err.Error(),
+		}
+		if errors.Is(err, errTimeout) || ctx.Err() != nil {
+			return result, err
+		}
+		return result, nil
+	}
+	return builds.TestResult{
+		Runner:   runner,
+		Status:   autopkgtestStatus(exitCode),
+		ExitCode: exitCode,
+		Output:   output,
+	}, nil
+}
+
+// testCheck turns a test result into the check result used to gate
+// promotions.
+func testCheck(result builds.TestResult) repo.CheckResult {
+	switch result.Status {
+	case builds.TestsPassed:
+		return repo.CheckPassed
+	case builds.TestsFailed, builds.TestsError:
+		return repo.CheckFailed
+	default:
+		return repo.CheckSkipped
+	}
+}
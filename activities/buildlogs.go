@@ -0,0 +1,77 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"go.temporal.io/sdk/temporal"
+)
+
+var ErrNoBuildLog = errors.New("no build log")
+
+// Error type of ErrNoBuildLog once it went through Temporal
+const NoBuildLogError = "NoBuildLog"
+
+// Characters of Debian package names, anything else could be a glob pattern
+// or leave the log directory
+var packageNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9+.-]*$`)
+
+// LatestBuildLog returns the path of the newest published build log of a
+// package.
+func LatestBuildLog(name string) (string, error) {
+	if !packageNamePattern.MatchString(name) {
+		return "", ErrNoBuildLog
+	}
+	logs, err := filepath.Glob(filepath.Join(BuildLogsDir, name+"_*.log"))
+	if err != nil {
+		return "", err
+	}
+	latest := ""
+	var latestTime int64
+	for _, log := range logs {
+		info, err := os.Stat(log)
+		if err != nil {
+			continue
+		}
+		if latest == "" || info.ModTime().UnixNano() > latestTime {
+			latest = log
+			latestTime = info.ModTime().UnixNano()
+		}
+	}
+	if latest == "" {
+		return "", ErrNoBuildLog
+	}
+	return latest, nil
+}
+
+// BuildLog is the tail of a published build log.
+type BuildLog struct {
+	File  string   `json:"file"`
+	Lines []string `json:"lines"`
+}
+
+// TailBuildLog returns the last lines of the newest build log of a package.
+// It runs on the build workers, the server may not have the build logs.
+func TailBuildLog(ctx context.Context, name string, lines int) (BuildLog, error) {
+	path, err := LatestBuildLog(name)
+	if errors.Is(err, ErrNoBuildLog) {
+		return BuildLog{}, temporal.NewNonRetryableApplicationError(err.Error(), NoBuildLogError, err)
+	}
+	if err != nil {
+		return BuildLog{}, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return BuildLog{}, err
+	}
+
+	content := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(content) > lines {
+		content = content[len(content)-lines:]
+	}
+	return BuildLog{File: filepath.Base(path), Lines: content}, nil
+}
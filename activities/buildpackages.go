@@ -2,13 +2,19 @@ package activities
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"pkbldr/builds"
 	"pkbldr/config"
+	"pkbldr/events"
+	"pkbldr/images"
+	"pkbldr/metrics"
 	"pkbldr/packages"
+	"pkbldr/repo"
 	"slices"
 	"strconv"
 	"strings"
@@ -21,23 +27,47 @@ import (
 	"golang.org/x/exp/slog"
 )
 
+// Mount location of the packages directory inside the containers
+const containerDir = "/data"
+
+// Build strategy of sources built by downloading the upstream packages
+const upstreamFallback = "upstream-fallback"
+
+// Directory the build logs are published to
+const BuildLogsDir = "/srv/www/buildlogs"
+
 func UpdateDockerContainer(ctx context.Context) error {
 	start := time.Now()
+	// The store of a worker is only loaded on startup and after fetches
+	packages.LoadFromDb()
+	if len(packages.GetBuildQueue()) == 0 {
+		fmt.Println("Build queue is empty, skipping builder image update")
+		return nil
+	}
+	pinned, err := images.Pinned()
+	if err != nil {
+		return err
+	}
+	if pinned != "" {
+		fmt.Println("Builder image pinned to " + pinned + ", skipping update")
+		return nil
+	}
+
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
 		return err
 	}
 
 	// Specify docker image and container name
-	imageName := "ghcr.io/pikaos-linux/pika-base-debian-container:latest"
-	containerName := "pikaos-bldr-container"
+	builder := config.Configs.Builder()
+	imageName := builder.BaseImage
+	containerName := builder.ContainerPrefix
 
 	workingDir, err := os.Getwd()
 	if err != nil {
 		return err
 	}
 	hostDir := filepath.Join(workingDir, "temppackagesdir")
-	containerDir := "/data" // Mount location inside the container
 
 	// Create local directory if it doesn't exist
 	if _, err := os.Stat(hostDir); os.IsNotExist(err) {
@@ -47,9 +77,14 @@ func UpdateDockerContainer(ctx context.Context) error {
 		}
 	}
 
+	upToDate, baseDigest := builderUpToDate(ctx, cli, builder)
+	if upToDate {
+		fmt.Println("Builder image is up to date, reusing it")
+		return nil
+	}
+
 	cli.ContainerRemove(ctx, containerName, types.ContainerRemoveOptions{Force: true})
 	forceKillContainers(ctx, cli, containerName)
-	cli.ImageRemove(ctx, imageName, types.ImageRemoveOptions{Force: true, PruneChildren: true})
 
 	fmt.Println("Pulling image...")
 	out, err := cli.ImagePull(ctx, imageName, types.ImagePullOptions{})
@@ -65,6 +100,7 @@ func UpdateDockerContainer(ctx context.Context) error {
 		WorkingDir: containerDir,
 		Cmd:        []string{"tail", "-f", "/dev/null"}, // Keep container running
 		Tty:        true,                                // Allocate a pseudo-TTY
+		Labels:     containerLabels(roleImage, ""),
 	}, &container.HostConfig{
 		Privileged: true,
 		Binds:      []string{fmt.Sprintf("%s:%s", hostDir, containerDir)},
@@ -78,7 +114,7 @@ func UpdateDockerContainer(ctx context.Context) error {
 		return err
 	}
 
-	command := "apt-get update -y && apt-get upgrade -y && apt-get autoremove -y && pika-pbuilder-amd64-init"
+	command := "apt-get update -y && apt-get upgrade -y && apt-get autoremove -y && " + builder.InitCommand
 
 	// Execute the command
 	execResp, err := cli.ContainerExecCreate(ctx, resp.ID, types.ExecConfig{
@@ -103,10 +139,11 @@ func UpdateDockerContainer(ctx context.Context) error {
 	io.Copy(os.Stdout, output.Reader)
 
 	cli.ContainerStop(ctx, resp.ID, container.StopOptions{})
-	_, err = cli.ContainerCommit(ctx, resp.ID, types.ContainerCommitOptions{Reference: "pikaos-bldr-container:latest"})
+	tag, err := images.Commit(ctx, cli, resp.ID, baseDigest)
 	if err != nil {
 		return err
 	}
+	fmt.Println("Committed builder image " + tag)
 
 	// Clean up (optional - you might want to keep the container)
 	fmt.Println("Stopping and removing container...")
@@ -116,8 +153,16 @@ func UpdateDockerContainer(ctx context.Context) error {
 
 }
 
+// StartBuildLoop builds the build queue in this process, for the builtin
+// scheduler and build-once. With Temporal every source is a build of its own,
+// see BuildSource. Packages an interrupted loop left behind are reset by
+// ReconcileBuilds on the next start.
 func StartBuildLoop(ctx context.Context) error {
+	ctx, cancel := stopOnWorkerStop(ctx)
+	defer cancel()
 
+	// Holds and rebuilds may have been set by the server since
+	packages.LoadFromDb()
 	pkgsToBuild := packages.GetBuildQueue()
 	start := time.Now()
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
@@ -126,49 +171,57 @@ func StartBuildLoop(ctx context.Context) error {
 	}
 
 	// Specify docker image and container name
-	imageName := "pikaos-bldr-container:latest"
-	containerName := "pikaos-bldr-container"
+	imageName := images.Latest()
+	containerName := config.Configs.Builder().ContainerPrefix
 
-	workingDir, err := os.Getwd()
+	hostDir, err := packagesDir()
 	if err != nil {
 		return err
 	}
-	hostDir := filepath.Join(workingDir, "temppackagesdir")
-	containerDir := "/data" // Mount location inside the container
-
-	// Create local directory if it doesn't exist
-	if _, err := os.Stat(hostDir); os.IsNotExist(err) {
-		err = os.MkdirAll(hostDir, 0755) // Change permissions if needed
-		if err != nil {
-			return err
-		}
-	}
 
 	forceKillContainers(ctx, cli, containerName)
 	containers, err := createContainers(ctx, cli, containerName, hostDir, containerDir, imageName)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		// The build context may be cancelled by now
+		fmt.Println("Stopping and removing container...")
+		cleanupCtx := context.WithoutCancel(ctx)
+		for _, containerID := range containers {
+			cli.ContainerStop(cleanupCtx, containerID, container.StopOptions{})
+			cli.ContainerRemove(cleanupCtx, containerID, types.ContainerRemoveOptions{Force: true})
+		}
+	}()
 
-	for _, pkg := range pkgsToBuild {
-		for _, pkg2 := range pkg {
-			pkg2.Status = packages.Queued
-			packages.UpdatePackage(pkg2, false)
+	for source, pkgs := range pkgsToBuild {
+		for i := range pkgs {
+			if pkgs[i].Status == packages.Queued {
+				continue
+			}
+			err := packages.Transition(&pkgs[i], packages.EventQueued, packages.Queued, events.SchedulerActor(), "queued for build")
+			if err != nil {
+				continue
+			}
+			packages.UpdatePackage(pkgs[i], true)
 		}
+		pkgsToBuild[source] = pkgs
 	}
 
 	fmt.Println("Build loop started")
 	// Loop through the packages and build them
-	err = buildBatch(pkgsToBuild, cli, containers, hostDir)
+	err = buildBatch(ctx, pkgsToBuild, cli, containers, hostDir)
 	if err != nil {
 		return err
 	}
+	if ctx.Err() != nil {
+		requeueUnstarted(pkgsToBuild)
+		return ctx.Err()
+	}
 
-	// Clean up (optional - you might want to keep the container)
-	fmt.Println("Stopping and removing container...")
-	for _, containerID := range containers {
-		cli.ContainerStop(ctx, containerID, container.StopOptions{})
-		cli.ContainerRemove(ctx, containerID, types.ContainerRemoveOptions{})
+	err = PublishRepository(ctx)
+	if err != nil {
+		slog.Error(err.Error())
 	}
 	fmt.Printf("Build loop took %s\n", time.Since(start))
 	return nil
@@ -176,12 +229,13 @@ func StartBuildLoop(ctx context.Context) error {
 
 func createContainers(ctx context.Context, cli *client.Client, containerName string, hostDir string, containerDir string, imageName string) ([]string, error) {
 	containers := make([]string, 0)
-	for i := 0; i < 3; i++ {
+	for i := 0; i < config.Configs.Concurrency(); i++ {
 		resp, err := cli.ContainerCreate(ctx, &container.Config{
 			Image:      imageName,
 			WorkingDir: containerDir,
 			Cmd:        []string{"tail", "-f", "/dev/null"}, // Keep container running
 			Tty:        true,                                // Allocate a pseudo-TTY
+			Labels:     containerLabels(roleLoop, ""),
 		}, &container.HostConfig{
 			Privileged: true,
 			Binds:      []string{fmt.Sprintf("%s:%s", hostDir, containerDir)},
@@ -201,46 +255,46 @@ func createContainers(ctx context.Context, cli *client.Client, containerName str
 }
 
 func forceKillContainers(ctx context.Context, cli *client.Client, containerName string) {
-	for i := 0; i < 3; i++ {
+	for i := 0; i < config.Configs.Concurrency(); i++ {
 		cli.ContainerRemove(ctx, containerName+"-"+strconv.Itoa(i), types.ContainerRemoveOptions{Force: true})
 	}
 }
 
-func buildBatch(packs packages.PackageBuildQueue, cli *client.Client, containers []string, hostDir string) error {
-	packageQueue := make(chan []packages.PackageInfo, 3)
-	// Create a worker pool with 3
+// buildBatch builds the queued sources, it stops starting new builds once ctx
+// is cancelled.
+func buildBatch(ctx context.Context, packs packages.PackageBuildQueue, cli *client.Client, containers []string, hostDir string) error {
+	packageQueue := make(chan string, len(packs))
+	// Add the packages to the queue
+	for source := range packs {
+		packageQueue <- source
+	}
+	// Close the queue to signal the workers to stop
+	close(packageQueue)
+
+	// One worker per container
 	var wg sync.WaitGroup
-	for i := 0; i < 3; i++ {
+	for i := 0; i < len(containers); i++ {
 		cont := containers[i]
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for {
-				select {
-				case pack, ok := <-packageQueue:
-					if !ok {
-						return
-					}
-					err := buildPackage(context.Background(), pack, cli, cont, hostDir)
-					if err != nil {
-						slog.Error(err.Error())
-					}
-				default:
-					// No more packages to process, exit the goroutine
+			for source := range packageQueue {
+				if ctx.Err() != nil {
 					return
 				}
+				err := buildPackage(ctx, packs[source], cli, cont, hostDir)
+				if err == nil {
+					// Without a worker pool the second build runs here, once
+					// the first one passed its checks
+					err = CheckReproducible(ctx, source)
+				}
+				if err != nil {
+					slog.Error(err.Error())
+				}
 			}
 		}()
 	}
 
-	// Add the packages to the queue
-	for _, v := range packs {
-		packageQueue <- v
-	}
-
-	// Close the queue to signal the workers to stop
-	close(packageQueue)
-
 	// Wait for all the workers to finish
 	wg.Wait()
 	return nil
@@ -253,10 +307,15 @@ func buildPackage(ctx context.Context, pkgs []packages.PackageInfo, cli *client.
 		buildVersion = pkg.Version
 	}
 	buildVersion = strings.ReplaceAll(buildVersion, "⟨1⟩:", "1:")
-	for _, pkg2 := range pkgs {
-		pkg2.Status = packages.Building
-		pkg2.LastBuildVersion = buildVersion
-		packages.UpdatePackage(pkg2, false)
+	for i := range pkgs {
+		err := packages.Transition(&pkgs[i], packages.EventBuildStarted, packages.Building, events.BuildWorkerActor(), "building version "+buildVersion)
+		if err != nil {
+			// Leave nothing of the source queued or building behind
+			releasePackages(pkgs, "build not started: "+err.Error())
+			return err
+		}
+		pkgs[i].LastBuildVersion = buildVersion
+		packages.UpdatePackage(pkgs[i], true)
 	}
 
 	// Create a temporary directory for the package
@@ -268,41 +327,61 @@ func buildPackage(ctx context.Context, pkgs []packages.PackageInfo, cli *client.
 	pkgdirs := strings.Split(dir, "/")
 	pkgdir := pkgdirs[len(pkgdirs)-1]
 
-	command := "cd " + pkgdir + " && eatmydata apt-get source " + pkg.Name + "=" + buildVersion + " -y"
-	// Execute the command
-	execResp, err := cli.ContainerExecCreate(ctx, respid, types.ExecConfig{
-		AttachStdout: true,
-		AttachStderr: true,
-		Cmd:          []string{"sh", "-c", command},
-		Tty:          true,
-		Privileged:   true,
-	})
+	source := pkg.Source
+	if source == "" {
+		source = pkg.Name
+	}
+	names := make([]string, 0, len(pkgs))
+	for _, pkg2 := range pkgs {
+		names = append(names, pkg2.Name)
+	}
+	build := builds.New(source, buildVersion, names, events.BuildWorkerActor().String())
+	inspect, err := cli.ContainerInspect(ctx, respid)
+	if err == nil {
+		build.BuilderImage = inspect.Image
+		build.BuilderTag = images.TagOf(ctx, cli, inspect.Image)
+	}
+	err = builds.Save(build)
 	if err != nil {
-		buildError(pkgs, err, dir)
-		return nil
+		slog.Error("unable to record build of " + source + ": " + err.Error())
 	}
+	metrics.BuildersBusy.Inc()
+	defer metrics.BuildersBusy.Dec()
+	defer func() {
+		if build.Strategy != "" && build.Outcome != builds.Cancelled {
+			metrics.ObserveSince(metrics.BuildDuration.WithLabelValues(build.Strategy), build.StartedAt)
+		}
+	}()
 
-	// Attach to the command's output
-	output, err := cli.ContainerExecAttach(ctx, execResp.ID, types.ExecStartCheck{Tty: true})
+	limits := buildLimits(pkgs)
+	command := "cd " + pkgdir + " && eatmydata apt-get source " + pkg.Name + "=" + buildVersion + " -y"
+	_, _, err = runLimited(ctx, cli, respid, command, limits)
+	if ctx.Err() != nil {
+		buildCancelled(build, pkgs, dir)
+		return ctx.Err()
+	}
 	if err != nil {
-		buildError(pkgs, err, dir)
+		buildError(build, pkgs, err, dir)
 		return nil
 	}
 
-	io.Copy(io.Discard, output.Reader)
-	output.Close()
-
 	loopNum := 0
 
 	for loopNum < 4 {
+		if ctx.Err() != nil {
+			buildCancelled(build, pkgs, dir)
+			return ctx.Err()
+		}
 		loopNum++
-		buildcmd := "pika-pbuilder-amd64-v3-lto-build"
+		profiles := config.Configs.Builder().Profiles
+		buildcmd := profiles[config.ProfileLTO]
 		if config.Configs.LTOBlocklist != nil && slices.Contains(config.Configs.LTOBlocklist, pkg.Name) || loopNum == 2 {
-			buildcmd = "pika-pbuilder-amd64-v3-build"
+			buildcmd = profiles[config.ProfileStandard]
 		}
 
 		if loopNum == 3 && config.Configs.UpstreamFallback {
 			fmt.Println("Falling back to upstream for: " + pkg.Name)
+			build.Strategy = upstreamFallback
 			for _, pkg3 := range pkgs {
 				bversion := pkg3.PendingVersion
 				if bversion == "" {
@@ -310,115 +389,159 @@ func buildPackage(ctx context.Context, pkgs []packages.PackageInfo, cli *client.
 				}
 				bversion = strings.ReplaceAll(bversion, "⟨1⟩:", "1:")
 				command := "cd " + pkgdir + " && eatmydata apt-get download " + pkg3.Name + "=" + bversion + " -y"
-				execResp, err = cli.ContainerExecCreate(ctx, respid, types.ExecConfig{
-					AttachStdout: true,
-					AttachStderr: true,
-					Cmd:          []string{"sh", "-c", command},
-					Tty:          true,
-					Privileged:   true,
-				})
-				if err != nil {
-					continue
+				_, _, err = runLimited(ctx, cli, respid, command, limits)
+				if errors.Is(err, errTimeout) {
+					buildError(build, pkgs, err, dir)
+					return nil
 				}
-
-				output, err = cli.ContainerExecAttach(ctx, execResp.ID, types.ExecStartCheck{Tty: true})
-				if err != nil {
-					continue
-				}
-				io.Copy(io.Discard, output.Reader)
-				output.Close()
 			}
 			command := "cd " + pkgdir + " && chmod 777 ./*.deb"
-			execResp, err = cli.ContainerExecCreate(ctx, respid, types.ExecConfig{
-				AttachStdout: true,
-				AttachStderr: true,
-				Cmd:          []string{"sh", "-c", command},
-				Tty:          true,
-				Privileged:   true,
-			})
-			if err != nil {
-				continue
+			_, _, err = runLimited(ctx, cli, respid, command, limits)
+			if errors.Is(err, errTimeout) {
+				buildError(build, pkgs, err, dir)
+				return nil
 			}
-			output, err = cli.ContainerExecAttach(ctx, execResp.ID, types.ExecStartCheck{Tty: true})
 			if err != nil {
 				continue
 			}
-			io.Copy(io.Discard, output.Reader)
-			output.Close()
 		} else {
+			build.Strategy = buildcmd
 			command = "cd " + pkgdir + " && " + buildcmd + " *.dsc"
-			// Execute the command
-			execResp, err = cli.ContainerExecCreate(ctx, respid, types.ExecConfig{
-				AttachStdout: true,
-				AttachStderr: true,
-				Cmd:          []string{"sh", "-c", command},
-				Tty:          true,
-				Privileged:   true,
-			})
-			if err != nil {
-				continue
+			_, _, err = runLimited(ctx, cli, respid, command, limits)
+			if errors.Is(err, errTimeout) {
+				fmt.Println("Build timed out for " + pkg.Name)
+				buildError(build, pkgs, err, dir)
+				return nil
 			}
-			// start the command
-			output, err = cli.ContainerExecAttach(ctx, execResp.ID, types.ExecStartCheck{Tty: true})
 			if err != nil {
 				continue
 			}
-			io.Copy(io.Discard, output.Reader)
-			output.Close()
+
 		}
 
-		if !checkBuild(pkgs, pkg, dir) {
+		err = checkBuild(ctx, cli, respid, build, pkgs, pkg, dir, buildVersion)
+		if ctx.Err() != nil {
+			buildCancelled(build, pkgs, dir)
+			return ctx.Err()
+		}
+		if errors.Is(err, errNoBuildOutput) {
 			fmt.Println("No build output for " + pkg.Name)
 			continue
+		}
+		if err != nil {
+			fmt.Println("Build output rejected for " + pkg.Name)
+			buildError(build, pkgs, err, dir)
+			return nil
+		}
+
+		fmt.Println("Build succeeded for " + pkg.Name)
+		err = builds.Finish(build, builds.Succeeded, "built version "+buildVersion)
+		if err != nil {
+			slog.Error("unable to record build of " + source + ": " + err.Error())
+		}
+		if build.Strategy == upstreamFallback {
+			metrics.BuildsTotal.WithLabelValues(metrics.OutcomeFallback).Inc()
 		} else {
-			fmt.Println("Build succeeded for " + pkg.Name)
-			for _, pkg2 := range pkgs {
-				pkg2.Status = packages.Uptodate
-				pkg2.LastBuildStatus = packages.Built
-				pkg2.BuildAttempts = 0
-				pkg2.Version = buildVersion
-				packages.UpdatePackage(pkg2, true)
+			metrics.BuildsTotal.WithLabelValues(metrics.OutcomeSucceeded).Inc()
+		}
+		for _, pkg2 := range pkgs {
+			err := packages.Transition(&pkg2, packages.EventBuildSucceeded, packages.Uptodate, events.BuildWorkerActor(), "built version "+buildVersion)
+			if err != nil {
+				continue
 			}
+			pkg2.LastBuildStatus = packages.Built
+			pkg2.LastBuildError = ""
+			pkg2.BuildAttempts = 0
+			pkg2.Version = buildVersion
+			pkg2.RebuildRequested = false
+			packages.UpdatePackage(pkg2, true)
 		}
 		os.RemoveAll(dir)
 		return nil
 	}
 
 	if loopNum > 2 {
-		buildError(pkgs, err, dir)
+		buildError(build, pkgs, errNoBuildOutput, dir)
 		return nil
 	}
 
 	return nil
 }
 
-func buildError(pkgs []packages.PackageInfo, err error, dir string) {
+func buildError(build *builds.Build, pkgs []packages.PackageInfo, err error, dir string) {
 	os.RemoveAll(dir)
 	if err != nil {
 		slog.Error(err.Error())
 	}
+	reason := errNoBuildOutput.Error()
+	if err != nil {
+		reason = err.Error()
+	}
+	err = builds.Finish(build, builds.Failed, reason)
+	if err != nil {
+		slog.Error("unable to record build of " + build.Source + ": " + err.Error())
+	}
+	metrics.BuildsTotal.WithLabelValues(metrics.OutcomeFailed).Inc()
 	for _, pkg2 := range pkgs {
+		err := packages.Transition(&pkg2, packages.EventBuildFailed, packages.Error, events.BuildWorkerActor(), reason)
+		if err != nil {
+			continue
+		}
 		pkg2.LastBuildStatus = packages.Error
+		pkg2.LastBuildError = reason
 		pkg2.BuildAttempts++
+		pkg2.RebuildRequested = false
 		packages.UpdatePackage(pkg2, true)
 	}
 }
 
-func checkBuild(pkgs []packages.PackageInfo, pkg packages.PackageInfo, dir string) bool {
-	// Check if there is a build
-	buildErr := true
+// buildCancelled puts the packages of a build that was called off back to
+// the status they had before they were queued.
+func buildCancelled(build *builds.Build, pkgs []packages.PackageInfo, dir string) {
+	os.RemoveAll(dir)
+	err := builds.Finish(build, builds.Cancelled, "build cancelled")
+	if err != nil {
+		slog.Error("unable to record build of " + build.Source + ": " + err.Error())
+	}
+	releasePackages(pkgs, "build cancelled")
+}
+
+// releasePackages puts the queued or building packages of a source back to
+// the status they had before they were queued.
+func releasePackages(pkgs []packages.PackageInfo, reason string) {
+	for _, pkg2 := range pkgs {
+		current, ok := packages.GetPackage(pkg2.Name)
+		if ok {
+			pkg2 = current
+		}
+		if !packages.IsInProgress(pkg2.Status) {
+			continue
+		}
+		err := packages.Transition(&pkg2, packages.EventCancelled, packages.PreQueueStatus(pkg2), events.BuildWorkerActor(), reason)
+		if err != nil {
+			continue
+		}
+		packages.UpdatePackage(pkg2, true)
+	}
+}
+
+// checkBuild publishes the build outputs in dir. It returns errNoBuildOutput
+// when the build produced no packages, and an inspection or installability
+// error when the produced packages don't match what was requested or can't
+// be installed.
+func checkBuild(ctx context.Context, cli *client.Client, containerID string, build *builds.Build, pkgs []packages.PackageInfo, pkg packages.PackageInfo, dir string, buildVersion string) error {
 	entries, err := os.ReadDir(dir)
 	if err != nil {
-		buildError(pkgs, err, dir)
-		return true
+		return err
 	}
+	debFiles := make([]string, 0)
 	for _, entry := range entries {
 		if strings.Contains(entry.Name(), "dbgsym") {
 			os.Remove(dir + "/" + entry.Name())
 			continue
 		}
 		if filepath.Ext(entry.Name()) == ".log" {
-			cmd := exec.Command("/bin/sh", "-c", "rsync -ah --progress --remove-source-files "+dir+"/"+entry.Name()+" /srv/www/buildlogs/"+pkg.Name+"_"+entry.Name())
+			cmd := exec.Command("/bin/sh", "-c", "rsync -ah --progress --remove-source-files "+dir+"/"+entry.Name()+" "+BuildLogsDir+"/"+pkg.Name+"_"+entry.Name())
 			cmd.Stdout = os.Stdout
 			cmd.Stderr = os.Stderr
 			cmd.Stdin = os.Stdin
@@ -426,7 +549,7 @@ func checkBuild(pkgs []packages.PackageInfo, pkg packages.PackageInfo, dir strin
 			if err != nil {
 				slog.Error(err.Error())
 			}
-			cmd = exec.Command("/bin/sh", "-c", "chmod 777 "+"/srv/www/buildlogs/"+pkg.Name+"_"+entry.Name())
+			cmd = exec.Command("/bin/sh", "-c", "chmod 777 "+BuildLogsDir+"/"+pkg.Name+"_"+entry.Name())
 			cmd.Stdout = os.Stdout
 			cmd.Stderr = os.Stderr
 			cmd.Stdin = os.Stdin
@@ -436,27 +559,106 @@ func checkBuild(pkgs []packages.PackageInfo, pkg packages.PackageInfo, dir strin
 			}
 		}
 		if filepath.Ext(entry.Name()) == ".deb" {
-			cmd := exec.Command("/bin/sh", "-c", "rsync -ah --progress --remove-source-files "+dir+"/"+entry.Name()+" "+config.Configs.DeboutputDir+entry.Name())
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			cmd.Stdin = os.Stdin
-			err = cmd.Run()
-			if err != nil {
-				slog.Error(err.Error())
-				continue
-			}
-			cmd = exec.Command("/bin/sh", "-c", "chmod 777 "+config.Configs.DeboutputDir+entry.Name())
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			cmd.Stdin = os.Stdin
-			err = cmd.Run()
-			if err != nil {
-				slog.Error(err.Error())
-				continue
-			}
-			buildErr = false
+			debFiles = append(debFiles, entry.Name())
+		}
+	}
+	if len(debFiles) == 0 {
+		return errNoBuildOutput
+	}
+
+	// Nothing gets published unless every artifact is what we asked for
+	for _, debFile := range debFiles {
+		err = inspectArtifact(pkgs, filepath.Join(dir, debFile), buildVersion)
+		if err != nil {
+			return err
+		}
+	}
+
+	checks := repo.BuildChecks{}
+	if config.Configs.InstallCheck {
+		err = installCheck(ctx, cli, build.Source, dir, debFiles)
+		if err != nil {
+			return err
+		}
+		checks.InstallCheck = repo.CheckPassed
+	}
+	if config.Configs.Autopkgtest.Enabled {
+		build.Autopkgtest, err = runAutopkgtest(ctx, cli, containerID, dir, debFiles)
+		if err != nil {
+			return err
+		}
+		checks.Autopkgtest = testCheck(build.Autopkgtest)
+	}
+
+	// Staged builds only reach the output directory when they are promoted
+	staging := repo.StagingEnabled()
+	published := make([]string, 0)
+	for _, debFile := range debFiles {
+		if staging {
+			published = append(published, filepath.Join(dir, debFile))
+			continue
+		}
+		cmd := exec.Command("/bin/sh", "-c", "rsync -ah --progress --remove-source-files "+dir+"/"+debFile+" "+config.Configs.DeboutputDir+debFile)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Stdin = os.Stdin
+		err = cmd.Run()
+		if err != nil {
+			slog.Error(err.Error())
 			continue
 		}
+		cmd = exec.Command("/bin/sh", "-c", "chmod 777 "+config.Configs.DeboutputDir+debFile)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Stdin = os.Stdin
+		err = cmd.Run()
+		if err != nil {
+			slog.Error(err.Error())
+			continue
+		}
+		published = append(published, config.Configs.DeboutputDir+debFile)
+	}
+	if len(published) == 0 {
+		return errNoBuildOutput
+	}
+	for _, debFile := range published {
+		build.Artifacts = append(build.Artifacts, filepath.Base(debFile))
+	}
+	err = writeManifest(build, dir, entries, published)
+	if err != nil {
+		slog.Error("unable to write the manifest of " + build.Source + ": " + err.Error())
+	}
+	if repo.Enabled() {
+		err = addToRepo(pkg, dir, entries, published, buildVersion, checks)
+		if err != nil && staging {
+			// The staging suite is the only place the outputs went to
+			return err
+		}
+		// Builds passing their checks may be due right away
+		err = repo.PromoteDue()
+		if err != nil {
+			slog.Error("unable to promote due builds: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// addToRepo copies the build outputs of a source package into the pool of
+// the generated repository.
+func addToRepo(pkg packages.PackageInfo, dir string, entries []os.DirEntry, debFiles []string, buildVersion string, checks repo.BuildChecks) error {
+	source := pkg.Source
+	if source == "" {
+		source = pkg.Name
+	}
+	dscFiles := make([]string, 0)
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) == ".dsc" {
+			dscFiles = append(dscFiles, filepath.Join(dir, entry.Name()))
+		}
+	}
+	err := repo.AddBuild(source, buildVersion, debFiles, dscFiles, checks)
+	if err != nil {
+		slog.Error("unable to add " + source + " to the repository: " + err.Error())
 	}
-	return !buildErr
+	return err
 }
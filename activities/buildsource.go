@@ -0,0 +1,184 @@
+package activities
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"pkbldr/config"
+	"pkbldr/events"
+	"pkbldr/images"
+	"pkbldr/packages"
+	"pkbldr/repo"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"go.temporal.io/sdk/temporal"
+)
+
+// Characters docker doesn't accept in container names
+var containerNameReplacer = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// packagesDir returns the host directory shared with the builder containers.
+func packagesDir() (string, error) {
+	workingDir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	hostDir := filepath.Join(workingDir, "temppackagesdir")
+
+	// Create local directory if it doesn't exist
+	if _, err := os.Stat(hostDir); os.IsNotExist(err) {
+		err = os.MkdirAll(hostDir, 0755) // Change permissions if needed
+		if err != nil {
+			return "", err
+		}
+	}
+	return hostDir, nil
+}
+
+// BuildPlan is what a build run has to do.
+type BuildPlan struct {
+	Sources []string `json:"sources"`
+	// Number of sources to build at the same time
+	Concurrency int `json:"concurrency"`
+}
+
+// QueueBuilds marks every source of the build queue as queued and returns
+// the sources to build.
+func QueueBuilds(ctx context.Context) (BuildPlan, error) {
+	// Holds and rebuilds may have been set by the server since
+	packages.LoadFromDb()
+	queue := packages.GetBuildQueue()
+	sources := make([]string, 0, len(queue))
+	for source, pkgs := range queue {
+		for i := range pkgs {
+			err := packages.Transition(&pkgs[i], packages.EventQueued, packages.Queued, events.SchedulerActor(), "queued for build")
+			if err != nil {
+				continue
+			}
+			packages.UpdatePackage(pkgs[i], true)
+		}
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	return BuildPlan{Sources: sources, Concurrency: config.Configs.Concurrency()}, nil
+}
+
+// CancelQueuedBuild takes the queued packages of a source out of the queue
+// again.
+func CancelQueuedBuild(ctx context.Context, source string, actor events.Actor) error {
+	err := packages.ReloadSource(source)
+	if err != nil {
+		return err
+	}
+	for _, pkg := range packages.GetPackagesBySource(source) {
+		if pkg.Status != packages.Queued {
+			continue
+		}
+		err := packages.Transition(&pkg, packages.EventCancelled, packages.PreQueueStatus(pkg), actor, "build cancelled")
+		if err != nil {
+			continue
+		}
+		packages.UpdatePackage(pkg, true)
+	}
+	return nil
+}
+
+// BuildSource builds the queued packages of a single source in a container
+// of its own. A failed build is reported as a non retryable error, the build
+// itself already tried every strategy.
+func BuildSource(ctx context.Context, source string) error {
+	ctx, cancel := stopOnWorkerStop(ctx)
+	defer cancel()
+
+	// The build run queued the source in another process
+	err := packages.ReloadSource(source)
+	if err != nil {
+		return err
+	}
+	pkgs := make([]packages.PackageInfo, 0)
+	for _, pkg := range packages.GetPackagesBySource(source) {
+		switch pkg.Status {
+		case packages.Building:
+			// An earlier attempt of this activity died mid build
+			err := packages.Transition(&pkg, packages.EventQueued, packages.Queued, events.SchedulerActor(), "build retried")
+			if err != nil {
+				continue
+			}
+			packages.UpdatePackage(pkg, true)
+		case packages.Queued:
+		default:
+			continue
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	if len(pkgs) == 0 {
+		return nil
+	}
+
+	start := time.Now()
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	hostDir, err := packagesDir()
+	if err != nil {
+		return err
+	}
+
+	containerName := config.Configs.Builder().ContainerPrefix + "-build-" + containerNameReplacer.ReplaceAllString(source, "_")
+	cli.ContainerRemove(ctx, containerName, types.ContainerRemoveOptions{Force: true})
+	resp, err := cli.ContainerCreate(ctx, &container.Config{
+		Image:      images.Latest(),
+		WorkingDir: containerDir,
+		Cmd:        []string{"tail", "-f", "/dev/null"}, // Keep container running
+		Tty:        true,                                // Allocate a pseudo-TTY
+		Labels:     containerLabels(roleSource, source),
+	}, &container.HostConfig{
+		Privileged: true,
+		Binds:      []string{fmt.Sprintf("%s:%s", hostDir, containerDir)},
+	}, nil, nil, containerName)
+	if err != nil {
+		return err
+	}
+	defer cli.ContainerRemove(context.Background(), resp.ID, types.ContainerRemoveOptions{Force: true})
+	if err := cli.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		return err
+	}
+
+	stopHeartbeat := startHeartbeat(ctx)
+	err = buildPackage(ctx, pkgs, cli, resp.ID, hostDir)
+	stopHeartbeat()
+	if err != nil {
+		// Includes the cancellation of the build
+		return err
+	}
+	fmt.Printf("Build of %s took %s\n", source, time.Since(start))
+
+	pkg, ok := packages.GetPackage(pkgs[0].Name)
+	if ok && pkg.Status == packages.Error {
+		return temporal.NewNonRetryableApplicationError("build of "+source+" failed: "+pkg.LastBuildError, "BuildFailed", nil)
+	}
+	return nil
+}
+
+// PublishRepository regenerates the indexes of every published suite.
+func PublishRepository(ctx context.Context) error {
+	if !repo.Enabled() {
+		return nil
+	}
+	for _, suite := range repo.Suites() {
+		err := repo.Publish(suite)
+		if err != nil {
+			return fmt.Errorf("unable to publish %s: %w", suite, err)
+		}
+	}
+	return nil
+}
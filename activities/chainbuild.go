@@ -0,0 +1,110 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"pkbldr/config"
+	"pkbldr/packages"
+	"time"
+
+	"go.temporal.io/api/filter/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/client"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Workflow type of the package build runs
+const buildRunWorkflow = "BuildPackages"
+
+var ErrNoBuildRun = errors.New("no build run in progress")
+
+var temporalClient client.Client
+
+// SetTemporalClient sets the client the activities use to look at other
+// workflows.
+func SetTemporalClient(c client.Client) {
+	temporalClient = c
+}
+
+// ChainPlan tells a fetch whether to trigger builds for its new work.
+type ChainPlan struct {
+	Trigger  bool          `json:"trigger"`
+	Debounce time.Duration `json:"debounce"`
+}
+
+// ChainTarget is the build run a fetch hands its new work to. Without a
+// workflow id no build run is open and Start tells whether a new one may be
+// started.
+type ChainTarget struct {
+	WorkflowID string `json:"workflowid"`
+	RunID      string `json:"runid"`
+	Start      bool   `json:"start"`
+}
+
+// OpenBuildRun returns the ids of the open build run. Scheduled and chained
+// runs get different workflow ids so it is looked up by type.
+func OpenBuildRun(ctx context.Context, c client.Client) (string, string, error) {
+	resp, err := c.ListOpenWorkflow(ctx, &workflowservice.ListOpenWorkflowExecutionsRequest{
+		MaximumPageSize: 1,
+		Filters: &workflowservice.ListOpenWorkflowExecutionsRequest_TypeFilter{
+			TypeFilter: &filter.WorkflowTypeFilter{Name: buildRunWorkflow},
+		},
+	})
+	if err != nil {
+		return "", "", err
+	}
+	if len(resp.GetExecutions()) == 0 {
+		return "", "", ErrNoBuildRun
+	}
+	execution := resp.GetExecutions()[0].GetExecution()
+	return execution.GetWorkflowId(), execution.GetRunId(), nil
+}
+
+// PlanChainedBuild decides whether the new work found by a fetch triggers
+// builds.
+func PlanChainedBuild(ctx context.Context, summary packages.FetchSummary) (ChainPlan, error) {
+	if !config.Configs.ChainBuild.Enabled || summary.Empty() {
+		return ChainPlan{}, nil
+	}
+	slog.Info("fetch found new work", "stale", len(summary.Stale), "missing", len(summary.Missing))
+	return ChainPlan{
+		Trigger:  true,
+		Debounce: parseDuration(config.Configs.ChainBuild.Debounce),
+	}, nil
+}
+
+// ChainedBuildTarget returns the build run to hand new work to. A new run
+// is only allowed when none started within the minimum interval.
+func ChainedBuildTarget(ctx context.Context) (ChainTarget, error) {
+	workflowID, runID, err := OpenBuildRun(ctx, temporalClient)
+	if err == nil {
+		return ChainTarget{WorkflowID: workflowID, RunID: runID}, nil
+	}
+	if !errors.Is(err, ErrNoBuildRun) {
+		return ChainTarget{}, err
+	}
+
+	minInterval := parseDuration(config.Configs.ChainBuild.MinInterval)
+	if minInterval <= 0 {
+		return ChainTarget{Start: true}, nil
+	}
+	resp, err := temporalClient.ListClosedWorkflow(ctx, &workflowservice.ListClosedWorkflowExecutionsRequest{
+		MaximumPageSize: 1,
+		StartTimeFilter: &filter.StartTimeFilter{
+			EarliestTime: timestamppb.New(time.Now().Add(-minInterval)),
+			LatestTime:   timestamppb.Now(),
+		},
+		Filters: &workflowservice.ListClosedWorkflowExecutionsRequest_TypeFilter{
+			TypeFilter: &filter.WorkflowTypeFilter{Name: buildRunWorkflow},
+		},
+	})
+	if err != nil {
+		return ChainTarget{}, err
+	}
+	if len(resp.GetExecutions()) > 0 {
+		slog.Info("not starting a build run, the last one started less than " + minInterval.String() + " ago")
+		return ChainTarget{}, nil
+	}
+	return ChainTarget{Start: true}, nil
+}
@@ -0,0 +1,141 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// Amount of command output kept for diagnostics
+const maxOutputSize = 16 * 1024
+
+// How often running commands are checked against their limits
+const watchInterval = 10 * time.Second
+
+var errTimeout = errors.New("timeout")
+
+// Distinguishes the pid files of concurrent commands
+var execCounter atomic.Int64
+
+// tailBuffer keeps the last maxOutputSize bytes written to it.
+type tailBuffer struct {
+	mu   sync.Mutex
+	data []byte
+	// Unix nanoseconds of the last write
+	lastWrite atomic.Int64
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastWrite.Store(time.Now().UnixNano())
+	t.data = append(t.data, p...)
+	if len(t.data) > maxOutputSize {
+		t.data = t.data[len(t.data)-maxOutputSize:]
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.data)
+}
+
+// execLimits bounds how long a command may run, zero values mean no limit.
+type execLimits struct {
+	// Wall clock deadline
+	Deadline time.Time
+	// Longest time the command may go without output
+	Inactivity time.Duration
+}
+
+// runLimited runs a shell command in a container and returns the tail of its
+// output together with its exit code. The command is killed when it trips
+// one of the limits, the returned error wraps errTimeout in that case.
+func runLimited(ctx context.Context, cli *client.Client, containerID string, command string, limits execLimits) (string, int, error) {
+	// The shell records its pid so the command can be killed, docker has no
+	// way to stop a single exec
+	pidFile := "/tmp/pkbldr-exec-" + strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.FormatInt(execCounter.Add(1), 10) + ".pid"
+	wrapped := "echo $$ > " + pidFile + "; (" + command + "); rc=$?; rm -f " + pidFile + "; exit $rc"
+
+	execResp, err := cli.ContainerExecCreate(ctx, containerID, types.ExecConfig{
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          []string{"sh", "-c", wrapped},
+		Tty:          true,
+		Privileged:   true,
+	})
+	if err != nil {
+		return "", -1, err
+	}
+
+	output, err := cli.ContainerExecAttach(ctx, execResp.ID, types.ExecStartCheck{Tty: true})
+	if err != nil {
+		return "", -1, err
+	}
+	defer output.Close()
+
+	var tail tailBuffer
+	tail.lastWrite.Store(time.Now().UnixNano())
+	done := make(chan struct{})
+	go func() {
+		io.Copy(&tail, output.Reader)
+		close(done)
+	}()
+
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	var tripped error
+	for tripped == nil {
+		select {
+		case <-done:
+			inspect, err := cli.ContainerExecInspect(ctx, execResp.ID)
+			if err != nil {
+				return tail.String(), -1, err
+			}
+			return tail.String(), inspect.ExitCode, nil
+		case <-ctx.Done():
+			tripped = ctx.Err()
+		case now := <-ticker.C:
+			if !limits.Deadline.IsZero() && now.After(limits.Deadline) {
+				tripped = fmt.Errorf("%w: command exceeded its time limit", errTimeout)
+			}
+			idle := now.Sub(time.Unix(0, tail.lastWrite.Load()))
+			if limits.Inactivity > 0 && idle > limits.Inactivity {
+				tripped = fmt.Errorf("%w: no output for %s", errTimeout, idle.Round(time.Second))
+			}
+		}
+	}
+
+	killExec(cli, containerID, pidFile)
+	output.Close()
+	select {
+	case <-done:
+	case <-time.After(watchInterval):
+	}
+	return tail.String(), -1, tripped
+}
+
+// killExec kills the process group of a command started by runLimited.
+func killExec(cli *client.Client, containerID string, pidFile string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	command := "pid=$(cat " + pidFile + ") && kill -KILL -- -$pid; kill -KILL $pid; rm -f " + pidFile
+	execResp, err := cli.ContainerExecCreate(ctx, containerID, types.ExecConfig{
+		Cmd:        []string{"sh", "-c", command},
+		Privileged: true,
+	})
+	if err != nil {
+		return
+	}
+	cli.ContainerExecStart(ctx, execResp.ID, types.ExecStartCheck{})
+}
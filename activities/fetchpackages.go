@@ -2,13 +2,21 @@ package activities
 
 import (
 	"context"
+	"log/slog"
 	"pkbldr/packages"
+	"pkbldr/repo"
 )
 
-func FetchPackages(ctx context.Context) error {
-	err := packages.ProcessPackages()
+func FetchPackages(ctx context.Context) (packages.FetchSummary, error) {
+	summary, err := packages.ProcessPackages()
 	if err != nil {
-		return err
+		return summary, err
 	}
-	return nil
+
+	// Staged builds are promoted on the hourly fetch schedule
+	err = repo.PromoteDue()
+	if err != nil {
+		slog.Error("unable to promote staged builds: " + err.Error())
+	}
+	return summary, nil
 }
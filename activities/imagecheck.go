@@ -0,0 +1,83 @@
+package activities
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"pkbldr/config"
+	"pkbldr/images"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// builderUpToDate reports whether the committed builder image is still
+// current: it was derived from the upstream base image digest and apt has
+// nothing to upgrade in it. It also returns the upstream digest, empty when
+// the registry couldn't be asked.
+func builderUpToDate(ctx context.Context, cli *client.Client, builder config.BuilderConfig) (bool, string) {
+	distribution, err := cli.DistributionInspect(ctx, builder.BaseImage, "")
+	if err != nil {
+		fmt.Println("Unable to check the base image digest: " + err.Error())
+		return false, ""
+	}
+	digest := distribution.Descriptor.Digest.String()
+
+	inspect, _, err := cli.ImageInspectWithRaw(ctx, images.Latest())
+	if err != nil || inspect.Config == nil {
+		return false, digest
+	}
+	if inspect.Config.Labels[images.BaseDigestLabel] != digest {
+		fmt.Println("Base image changed to " + digest)
+		return false, digest
+	}
+
+	// Workers on the same host may check the image at the same time
+	probeName := builder.ContainerPrefix + "-probe-" + strconv.Itoa(os.Getpid())
+	resp, err := cli.ContainerCreate(ctx, &container.Config{
+		Image:  images.Latest(),
+		Cmd:    []string{"tail", "-f", "/dev/null"}, // Keep container running
+		Tty:    true,                                // Allocate a pseudo-TTY
+		Labels: containerLabels(roleProbe, ""),
+	}, &container.HostConfig{}, nil, nil, probeName)
+	if err != nil {
+		return false, digest
+	}
+	defer cli.ContainerRemove(context.Background(), resp.ID, types.ContainerRemoveOptions{Force: true})
+	if err := cli.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		return false, digest
+	}
+
+	// Only the count is printed, the output of runLimited is just a tail
+	output, exitCode, err := runLimited(ctx, cli, resp.ID, upgradeCountCommand, checkLimits())
+	if err != nil || exitCode != 0 {
+		return false, digest
+	}
+	upgrades, err := pendingUpgrades(output)
+	if err != nil {
+		fmt.Println("Unable to check the builder image for upgrades: " + err.Error())
+		return false, digest
+	}
+	if upgrades > 0 {
+		fmt.Println("Builder image has " + strconv.Itoa(upgrades) + " pending upgrades")
+		return false, digest
+	}
+	return true, digest
+}
+
+// Prints the number of packages apt would upgrade, grep exits non-zero when
+// there are none
+const upgradeCountCommand = "apt-get update -y > /dev/null && apt-get -s upgrade > /tmp/pkbldr-upgrades && { grep -c '^Inst ' /tmp/pkbldr-upgrades || true; }"
+
+// pendingUpgrades reads the count printed by upgradeCountCommand from the
+// last line of its output.
+func pendingUpgrades(output string) (int, error) {
+	fields := strings.Fields(output)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("no upgrade count in the output")
+	}
+	return strconv.Atoi(fields[len(fields)-1])
+}
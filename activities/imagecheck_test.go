@@ -0,0 +1,26 @@
+package activities
+
+import "testing"
+
+func TestPendingUpgrades(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    int
+		wantErr bool
+	}{
+		{"none", "0\r\n", 0, false},
+		{"some", "12\r\n", 12, false},
+		{"after noise", "W: some warning\r\n3\r\n", 3, false},
+		{"empty", "", 0, true},
+		{"no count", "E: Could not get lock\r\n", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := pendingUpgrades(tt.output)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("pendingUpgrades(%q) = %d, %v, want %d", tt.output, got, err, tt.want)
+			}
+		})
+	}
+}
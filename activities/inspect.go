@@ -0,0 +1,88 @@
+package activities
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"pkbldr/config"
+	"pkbldr/deb"
+	"pkbldr/packages"
+	"strings"
+
+	"pault.ag/go/debian/version"
+)
+
+var errNoBuildOutput = errors.New("no usable build output")
+
+// inspectArtifact checks that a built .deb belongs to the source group that
+// was built, in the requested version and for the right architecture.
+func inspectArtifact(pkgs []packages.PackageInfo, debFile string, buildVersion string) error {
+	name := filepath.Base(debFile)
+	stanza, err := deb.GetControlFileFromDeb(debFile)
+	if err != nil {
+		return fmt.Errorf("artifact %s is not a valid package: %w", name, err)
+	}
+
+	source := pkgs[0].Source
+	if source == "" {
+		source = pkgs[0].Name
+	}
+	debSource, sourceVersion := splitSourceField(stanza)
+
+	belongs := debSource == source
+	architectures := []string{"all", config.Configs.Architecture()}
+	for _, pkg := range pkgs {
+		if pkg.Name == stanza["Package"] {
+			belongs = true
+		}
+		architectures = append(architectures, pkg.Architecture)
+	}
+	if !belongs {
+		return fmt.Errorf("artifact %s: package %s (source %s) does not belong to source %s", name, stanza["Package"], debSource, source)
+	}
+
+	if !sameVersion(stanza["Version"], buildVersion) && !sameVersion(sourceVersion, buildVersion) {
+		return fmt.Errorf("artifact %s: version %s does not match requested version %s", name, stanza["Version"], buildVersion)
+	}
+
+	arch := stanza["Architecture"]
+	matched := false
+	for _, a := range architectures {
+		if a == arch {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return fmt.Errorf("artifact %s: architecture %s does not match %s", name, arch, config.Configs.Architecture())
+	}
+
+	return nil
+}
+
+// splitSourceField returns the source name and version of a binary stanza.
+// The Source field carries the source version in parentheses when it
+// differs from the binary version.
+func splitSourceField(stanza deb.Stanza) (string, string) {
+	field := stanza["Source"]
+	if field == "" {
+		return stanza["Package"], stanza["Version"]
+	}
+	name, ver, found := strings.Cut(field, " ")
+	if !found {
+		return name, stanza["Version"]
+	}
+	return name, strings.Trim(strings.TrimSpace(ver), "()")
+}
+
+func sameVersion(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	verA, errA := version.Parse(a)
+	verB, errB := version.Parse(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return version.Compare(verA, verB) == 0
+}
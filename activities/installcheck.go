@@ -0,0 +1,51 @@
+package activities
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"pkbldr/config"
+	"pkbldr/images"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// installCheck installs the built packages into a fresh container of the
+// builder image and lets apt verify the dependencies. The returned error
+// carries apt's diagnostics.
+func installCheck(ctx context.Context, cli *client.Client, source string, dir string, debFiles []string) error {
+	hostDir := filepath.Dir(dir)
+	pkgdir := filepath.Base(dir)
+
+	resp, err := cli.ContainerCreate(ctx, &container.Config{
+		Image:      images.Latest(),
+		WorkingDir: containerDir,
+		Cmd:        []string{"tail", "-f", "/dev/null"}, // Keep container running
+		Tty:        true,                                // Allocate a pseudo-TTY
+		Labels:     containerLabels(roleCheck, source),
+	}, &container.HostConfig{
+		Binds: []string{fmt.Sprintf("%s:%s", hostDir, containerDir)},
+	}, nil, nil, config.Configs.Builder().ContainerPrefix+"-check-"+pkgdir)
+	if err != nil {
+		return err
+	}
+	defer cli.ContainerRemove(context.Background(), resp.ID, types.ContainerRemoveOptions{Force: true})
+
+	if err := cli.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		return err
+	}
+
+	debs := "./" + strings.Join(debFiles, " ./")
+	command := "cd " + pkgdir + " && apt-get update -y && DEBIAN_FRONTEND=noninteractive apt-get install -y --no-install-recommends " + debs + " && apt-get check"
+	output, exitCode, err := runLimited(ctx, cli, resp.ID, command, checkLimits())
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("installability check failed:\n%s", output)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package activities
+
+import (
+	"os"
+	"path/filepath"
+	"pkbldr/builds"
+	"pkbldr/config"
+	"time"
+)
+
+// writeManifest puts the signed manifest of a successful build on its build
+// record. debFiles are the published paths of the outputs.
+func writeManifest(build *builds.Build, dir string, entries []os.DirEntry, debFiles []string) error {
+	manifest := builds.Manifest{
+		Source:       build.Source,
+		Version:      build.Version,
+		BuilderImage: build.BuilderImage,
+		BuilderTag:   build.BuilderTag,
+		Strategy:     build.Strategy,
+		Worker:       build.Worker,
+		Artifacts:    make([]builds.Artifact, 0, len(debFiles)),
+		CreatedAt:    time.Now().UTC(),
+	}
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != ".dsc" {
+			continue
+		}
+		hash, err := sha256File(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		manifest.Dsc = entry.Name()
+		manifest.DscSHA256 = hash
+		break
+	}
+	for _, debFile := range debFiles {
+		hash, err := sha256File(debFile)
+		if err != nil {
+			return err
+		}
+		manifest.Artifacts = append(manifest.Artifacts, builds.Artifact{
+			Name:   filepath.Base(debFile),
+			SHA256: hash,
+		})
+	}
+	build.ArtifactHashes = make(map[string]string, len(manifest.Artifacts))
+	for _, artifact := range manifest.Artifacts {
+		build.ArtifactHashes[artifact.Name] = artifact.SHA256
+	}
+
+	key := config.Configs.ManifestSigningKey
+	if key == "" {
+		key = config.Configs.Repo.SigningKey
+	}
+	signed, err := builds.Sign(manifest, key)
+	if err != nil {
+		return err
+	}
+	build.Manifest = signed
+	return nil
+}
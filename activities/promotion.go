@@ -0,0 +1,28 @@
+package activities
+
+import (
+	"context"
+	"pkbldr/events"
+	"pkbldr/repo"
+
+	"go.temporal.io/sdk/temporal"
+)
+
+// PromoteRequest names a staged build to promote and who asked for it.
+type PromoteRequest struct {
+	Source  string       `json:"source"`
+	Version string       `json:"version"`
+	Actor   events.Actor `json:"actor"`
+	Reason  string       `json:"reason"`
+}
+
+// PromoteBuild promotes a staged build. It runs on the build workers, the
+// server may not have the repository.
+func PromoteBuild(ctx context.Context, request PromoteRequest) error {
+	err := repo.Promote(request.Source, request.Version, request.Actor, request.Reason)
+	if err != nil {
+		// Staging state and checks don't change by retrying
+		return temporal.NewNonRetryableApplicationError(err.Error(), "PromotionFailed", err)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package activities
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"pkbldr/builds"
+	"pkbldr/events"
+	"pkbldr/packages"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+)
+
+// Labels of the containers the builder creates, ReconcileBuilds finds the
+// containers a crashed process left behind by them
+const (
+	labelRole   = "pkbldr.role"
+	labelSource = "pkbldr.source"
+	// Host and pid of the process that created the container
+	labelOwner = "pkbldr.owner"
+)
+
+// Roles of the builder containers
+const (
+	roleImage   = "image"
+	roleLoop    = "loop"
+	roleSource  = "source"
+	roleRebuild = "rebuild"
+	roleProbe   = "probe"
+	roleCheck   = "check"
+)
+
+// containerLabels returns the labels of a container created by this process.
+func containerLabels(role string, source string) map[string]string {
+	hostname, _ := os.Hostname()
+	labels := map[string]string{
+		labelRole:  role,
+		labelOwner: hostname + "/" + strconv.Itoa(os.Getpid()),
+	}
+	if source != "" {
+		labels[labelSource] = source
+	}
+	return labels
+}
+
+// ownerAlive reports whether the process that created a container is still
+// running. Containers of other hosts are assumed to be owned.
+func ownerAlive(owner string) bool {
+	host, pid, ok := strings.Cut(owner, "/")
+	if !ok {
+		return false
+	}
+	hostname, _ := os.Hostname()
+	if host != hostname {
+		return true
+	}
+	id, err := strconv.Atoi(pid)
+	if err != nil {
+		return false
+	}
+	if id == os.Getpid() {
+		// Left behind by an earlier process with the same pid
+		return false
+	}
+	process, err := os.FindProcess(id)
+	if err != nil {
+		return false
+	}
+	return process.Signal(syscall.Signal(0)) == nil
+}
+
+// Reconciliation is what ReconcileBuilds found and fixed.
+type Reconciliation struct {
+	// A build run or a live build loop owns every in-progress package
+	Owned bool `json:"owned"`
+	// Sources a live build container still owns
+	OwnedSources []string `json:"ownedsources"`
+	// Packages put back to their pre-queue status
+	Reset []string `json:"reset"`
+	// Orphaned containers that were removed
+	Containers int `json:"containers"`
+	// Build records closed as cancelled
+	Builds int `json:"builds"`
+}
+
+// ReconcileBuilds fixes up what a crashed process left behind. Packages
+// stuck queued or building go back to their pre-queue status so the next
+// build run picks them up, unless an open build run or the live process of
+// a build container still owns them. Orphaned containers are removed and
+// build records still marked as running are closed. It runs on startup,
+// after the packages are loaded from the database.
+func ReconcileBuilds(ctx context.Context) (Reconciliation, error) {
+	var result Reconciliation
+	if temporalClient != nil {
+		_, _, err := OpenBuildRun(ctx, temporalClient)
+		if err == nil {
+			result.Owned = true
+			return result, nil
+		}
+		if !errors.Is(err, ErrNoBuildRun) {
+			return result, fmt.Errorf("unable to look up the build run: %w", err)
+		}
+	}
+
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return result, err
+	}
+	defer cli.Close()
+	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", labelRole)),
+	})
+	if err != nil {
+		return result, fmt.Errorf("unable to list builder containers: %w", err)
+	}
+
+	owned := make(map[string]bool)
+	for _, cont := range containers {
+		if ownerAlive(cont.Labels[labelOwner]) {
+			switch cont.Labels[labelRole] {
+			case roleLoop:
+				result.Owned = true
+			case roleSource:
+				owned[cont.Labels[labelSource]] = true
+			}
+			continue
+		}
+		err := cli.ContainerRemove(ctx, cont.ID, types.ContainerRemoveOptions{Force: true})
+		if err != nil {
+			slog.Error("unable to remove orphaned container: " + err.Error())
+			continue
+		}
+		result.Containers++
+	}
+	if result.Owned {
+		return result, nil
+	}
+
+	ownedNames := make(map[string]bool)
+	for source := range owned {
+		result.OwnedSources = append(result.OwnedSources, source)
+		for _, pkg := range packages.GetPackagesBySource(source) {
+			ownedNames[pkg.Name] = true
+		}
+	}
+
+	for _, pkg := range packages.GetPackagesSlice() {
+		if !packages.IsInProgress(pkg.Status) || ownedNames[pkg.Name] {
+			continue
+		}
+		err := packages.Transition(&pkg, packages.EventCancelled, packages.PreQueueStatus(pkg), events.SchedulerActor(), "build interrupted, recovered on startup")
+		if err != nil {
+			continue
+		}
+		err = packages.UpdatePackage(pkg, true)
+		if err != nil {
+			return result, err
+		}
+		result.Reset = append(result.Reset, pkg.Name)
+	}
+
+	unfinished, err := builds.Unfinished()
+	if err != nil {
+		return result, err
+	}
+	for _, build := range unfinished {
+		if owned[build.Source] {
+			continue
+		}
+		err := builds.Finish(&build, builds.Cancelled, "build interrupted, the builder process went away")
+		if err != nil {
+			return result, err
+		}
+		result.Builds++
+	}
+	return result, nil
+}
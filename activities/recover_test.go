@@ -0,0 +1,47 @@
+package activities
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+)
+
+func TestOwnerAlive(t *testing.T) {
+	hostname, _ := os.Hostname()
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Skip("unable to run a short lived process: " + err.Error())
+	}
+	tests := []struct {
+		name  string
+		owner string
+		want  bool
+	}{
+		{"no owner", "", false},
+		{"malformed", "builder", false},
+		{"other host", hostname + "-elsewhere/1", true},
+		{"live process", hostname + "/" + strconv.Itoa(os.Getppid()), true},
+		{"exited process", hostname + "/" + strconv.Itoa(exited.Process.Pid), false},
+		{"same pid as this process", hostname + "/" + strconv.Itoa(os.Getpid()), false},
+		{"invalid pid", hostname + "/abc", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ownerAlive(tt.owner); got != tt.want {
+				t.Errorf("ownerAlive(%q) = %v, want %v", tt.owner, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContainerLabels(t *testing.T) {
+	hostname, _ := os.Hostname()
+	labels := containerLabels(roleSource, "hello")
+	if labels[labelRole] != roleSource || labels[labelSource] != "hello" || labels[labelOwner] != hostname+"/"+strconv.Itoa(os.Getpid()) {
+		t.Errorf("containerLabels() = %v", labels)
+	}
+	if _, ok := containerLabels(roleLoop, "")[labelSource]; ok {
+		t.Error("loop container labelled with a source")
+	}
+}
@@ -0,0 +1,218 @@
+package activities
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"pkbldr/builds"
+	"pkbldr/config"
+	"pkbldr/events"
+	"pkbldr/images"
+	"pkbldr/packages"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// CheckReproducible builds the latest successful build of a source a second
+// time in a fresh container and compares the checksums of the .debs with the
+// published ones. It runs after the build passed its checks, as an activity
+// of its own so the second build can run on another worker of the pool.
+func CheckReproducible(ctx context.Context, source string) error {
+	if !config.Configs.ReproducibilityCheck {
+		return nil
+	}
+	recent, err := builds.ForSource(source, 1, 0)
+	if err != nil {
+		return err
+	}
+	if len(recent) == 0 || recent[0].Outcome != builds.Succeeded || recent[0].Strategy == upstreamFallback {
+		// Nothing we built to compare with
+		return nil
+	}
+	build := recent[0]
+	err = packages.ReloadSource(source)
+	if err != nil {
+		return err
+	}
+	pkgs := packages.GetPackagesBySource(source)
+
+	if len(build.ArtifactHashes) == 0 {
+		// A second build would have nothing to be compared with
+		reason := "build " + build.ID + " recorded no artifact checksums"
+		slog.Warn("skipping reproducibility check of " + source + ": " + reason)
+		return saveReproducibility(build, pkgs, builds.Reproducibility{Status: builds.ReproUnknown, Differences: []string{reason}})
+	}
+
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+	hostDir, err := packagesDir()
+	if err != nil {
+		return err
+	}
+
+	stopHeartbeat := startHeartbeat(ctx)
+	result := rebuildAndCompare(ctx, cli, hostDir, build, buildLimits(pkgs))
+	stopHeartbeat()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return saveReproducibility(build, pkgs, result)
+}
+
+// saveReproducibility stores the result of a check on the build and its
+// packages.
+func saveReproducibility(build builds.Build, pkgs []packages.PackageInfo, result builds.Reproducibility) error {
+	build.Reproducibility = result
+	err := builds.Save(&build)
+	if err != nil {
+		return err
+	}
+	for _, pkg := range pkgs {
+		setReproducibility(&pkg, result)
+		packages.UpdatePackage(pkg, true)
+	}
+	return nil
+}
+
+// rebuildAndCompare builds the source of build a second time and compares
+// the outputs with the checksums recorded for its artifacts, the worker
+// doesn't need the first build's files.
+func rebuildAndCompare(ctx context.Context, cli *client.Client, hostDir string, build builds.Build, limits execLimits) builds.Reproducibility {
+	first := build.ArtifactHashes
+	rebuildDir, err := os.MkdirTemp(hostDir, build.Source+"-rebuild")
+	if err != nil {
+		return builds.Reproducibility{Status: builds.ReproError, Differences: []string{err.Error()}}
+	}
+	defer os.RemoveAll(rebuildDir)
+	pkgdir := filepath.Base(rebuildDir)
+
+	resp, err := cli.ContainerCreate(ctx, &container.Config{
+		Image:      images.Latest(),
+		WorkingDir: containerDir,
+		Cmd:        []string{"tail", "-f", "/dev/null"}, // Keep container running
+		Tty:        true,                                // Allocate a pseudo-TTY
+		Labels:     containerLabels(roleRebuild, build.Source),
+	}, &container.HostConfig{
+		Privileged: true,
+		Binds:      []string{fmt.Sprintf("%s:%s", hostDir, containerDir)},
+	}, nil, nil, config.Configs.Builder().ContainerPrefix+"-rebuild-"+pkgdir)
+	if err != nil {
+		return builds.Reproducibility{Status: builds.ReproError, Differences: []string{err.Error()}}
+	}
+	defer cli.ContainerRemove(context.Background(), resp.ID, types.ContainerRemoveOptions{Force: true})
+
+	if err := cli.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		return builds.Reproducibility{Status: builds.ReproError, Differences: []string{err.Error()}}
+	}
+
+	command := "cd " + pkgdir + " && eatmydata apt-get source " + build.Source + "=" + build.Version + " -y && " + build.Strategy + " *.dsc"
+	output, exitCode, err := runLimited(ctx, cli, resp.ID, command, limits)
+	if err != nil {
+		// Includes tripped time limits
+		return builds.Reproducibility{Status: builds.ReproError, Differences: []string{err.Error()}}
+	}
+	second, err := debHashes(rebuildDir)
+	if err != nil {
+		return builds.Reproducibility{Status: builds.ReproError, Differences: []string{err.Error()}}
+	}
+	if len(second) == 0 {
+		return builds.Reproducibility{
+			Status:      builds.ReproError,
+			Differences: []string{fmt.Sprintf("rebuild produced no packages (exit code %d):\n%s", exitCode, output)},
+		}
+	}
+
+	return compareHashes(first, second)
+}
+
+// compareHashes summarizes the differences between two sets of .deb
+// checksums.
+func compareHashes(first, second map[string]string) builds.Reproducibility {
+	differences := make([]string, 0)
+	for name, hash := range first {
+		other, ok := second[name]
+		switch {
+		case !ok:
+			differences = append(differences, name+": missing from the rebuild")
+		case other != hash:
+			differences = append(differences, name+": sha256 "+hash+" != "+other)
+		}
+	}
+	for name := range second {
+		if _, ok := first[name]; !ok {
+			differences = append(differences, name+": only produced by the rebuild")
+		}
+	}
+	sort.Strings(differences)
+
+	if len(differences) == 0 {
+		return builds.Reproducibility{Status: builds.Reproducible}
+	}
+	return builds.Reproducibility{Status: builds.Unreproducible, Differences: differences}
+}
+
+// debHashes returns the sha256 of every .deb in dir, by file name. Debug
+// symbol packages are left out as they never get published.
+func debHashes(dir string) (map[string]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make(map[string]string)
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != ".deb" || strings.Contains(entry.Name(), "dbgsym") {
+			continue
+		}
+		hash, err := sha256File(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		hashes[entry.Name()] = hash
+	}
+	return hashes, nil
+}
+
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// setReproducibility flags a package with the outcome of its latest
+// reproducibility check and logs changes of that flag.
+func setReproducibility(pkg *packages.PackageInfo, result builds.Reproducibility) {
+	status := string(result.Status)
+	if pkg.Reproducibility == status {
+		return
+	}
+	events.Record(events.Event{
+		Kind:    events.StatusChange,
+		Package: pkg.Name,
+		Actor:   events.BuildWorkerActor(),
+		Field:   "reproducibility",
+		Before:  pkg.Reproducibility,
+		After:   status,
+		Reason:  strings.Join(result.Differences, "\n"),
+	})
+	pkg.Reproducibility = status
+}
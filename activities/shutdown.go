@@ -0,0 +1,46 @@
+package activities
+
+import (
+	"context"
+	"pkbldr/events"
+	"pkbldr/packages"
+
+	"go.temporal.io/sdk/activity"
+)
+
+// stopOnWorkerStop returns a context that is also cancelled when the worker
+// running the activity shuts down, builds are called off right away instead
+// of running into the stop timeout.
+func stopOnWorkerStop(ctx context.Context) (context.Context, context.CancelFunc) {
+	if !activity.IsActivity(ctx) {
+		return context.WithCancel(ctx)
+	}
+	stop := activity.GetWorkerStopChannel(ctx)
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// requeueUnstarted puts the packages of a queue that are still waiting for a
+// build back to the status they had before they were queued.
+func requeueUnstarted(queue packages.PackageBuildQueue) {
+	for _, pkgs := range queue {
+		for _, pkg := range pkgs {
+			current, ok := packages.GetPackage(pkg.Name)
+			if !ok || current.Status != packages.Queued {
+				continue
+			}
+			err := packages.Transition(&current, packages.EventCancelled, packages.PreQueueStatus(current), events.BuildWorkerActor(), "build loop stopped")
+			if err != nil {
+				continue
+			}
+			packages.UpdatePackage(current, true)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package activities
+
+import (
+	"log/slog"
+	"pkbldr/config"
+	"pkbldr/packages"
+	"time"
+)
+
+// parseDuration parses a configured duration, empty and invalid values count
+// as zero.
+func parseDuration(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		slog.Error("invalid duration " + value + ": " + err.Error())
+		return 0
+	}
+	return duration
+}
+
+// buildLimits returns the limits of a build of the packages of one source
+// starting now. A timeout set for the source or any of its packages takes
+// precedence over the global one.
+func buildLimits(pkgs []packages.PackageInfo) execLimits {
+	timeout := parseDuration(config.Configs.BuildTimeout)
+	for _, pkg := range pkgs {
+		for _, name := range []string{pkg.Name, pkg.Source} {
+			if value, ok := config.Configs.BuildTimeouts[name]; ok && name != "" {
+				timeout = parseDuration(value)
+			}
+		}
+	}
+
+	limits := execLimits{
+		Inactivity: parseDuration(config.Configs.BuildInactivityTimeout),
+	}
+	if timeout > 0 {
+		limits.Deadline = time.Now().Add(timeout)
+	}
+	return limits
+}
+
+// checkLimits returns the limits of a check of build outputs starting now.
+func checkLimits() execLimits {
+	limits := execLimits{
+		Inactivity: parseDuration(config.Configs.BuildInactivityTimeout),
+	}
+	timeout := parseDuration(config.Configs.CheckTimeoutValue())
+	if timeout > 0 {
+		limits.Deadline = time.Now().Add(timeout)
+	}
+	return limits
+}
@@ -0,0 +1,113 @@
+package activities
+
+import (
+	"pkbldr/config"
+	"pkbldr/packages"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildLimits(t *testing.T) {
+	tests := []struct {
+		name       string
+		timeout    string
+		timeouts   map[string]string
+		inactivity string
+		pkgs       []packages.PackageInfo
+		want       time.Duration
+		wantIdle   time.Duration
+	}{
+		{
+			name: "unlimited",
+			pkgs: []packages.PackageInfo{{Name: "hello", Source: "hello"}},
+		},
+		{
+			name:       "global timeout",
+			timeout:    "2h",
+			inactivity: "30m",
+			pkgs:       []packages.PackageInfo{{Name: "hello", Source: "hello"}},
+			want:       2 * time.Hour,
+			wantIdle:   30 * time.Minute,
+		},
+		{
+			name:     "source timeout",
+			timeout:  "2h",
+			timeouts: map[string]string{"linux": "12h"},
+			pkgs:     []packages.PackageInfo{{Name: "linux-image", Source: "linux"}},
+			want:     12 * time.Hour,
+		},
+		{
+			name:     "package timeout",
+			timeout:  "2h",
+			timeouts: map[string]string{"linux-image": "8h"},
+			pkgs:     []packages.PackageInfo{{Name: "linux-headers", Source: "linux"}, {Name: "linux-image", Source: "linux"}},
+			want:     8 * time.Hour,
+		},
+		{
+			name:    "invalid timeout",
+			timeout: "forever",
+			pkgs:    []packages.PackageInfo{{Name: "hello", Source: "hello"}},
+		},
+	}
+	defer func(c config.Config) { config.Configs = c }(config.Configs)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Configs.BuildTimeout = tt.timeout
+			config.Configs.BuildTimeouts = tt.timeouts
+			config.Configs.BuildInactivityTimeout = tt.inactivity
+			start := time.Now()
+			limits := buildLimits(tt.pkgs)
+			checkDeadline(t, limits, start, tt.want)
+			if limits.Inactivity != tt.wantIdle {
+				t.Errorf("inactivity = %v, want %v", limits.Inactivity, tt.wantIdle)
+			}
+		})
+	}
+}
+
+func TestCheckLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout string
+		want    time.Duration
+	}{
+		{"default", "", 2 * time.Hour},
+		{"configured", "45m", 45 * time.Minute},
+		{"invalid", "soon", 0},
+	}
+	defer func(c config.Config) { config.Configs = c }(config.Configs)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Configs.CheckTimeout = tt.timeout
+			start := time.Now()
+			checkDeadline(t, checkLimits(), start, tt.want)
+		})
+	}
+}
+
+func checkDeadline(t *testing.T, limits execLimits, start time.Time, want time.Duration) {
+	t.Helper()
+	if want == 0 {
+		if !limits.Deadline.IsZero() {
+			t.Errorf("deadline = %v, want none", limits.Deadline)
+		}
+		return
+	}
+	if limits.Deadline.Before(start.Add(want)) || limits.Deadline.After(time.Now().Add(want)) {
+		t.Errorf("deadline = %v, want %v from %v", limits.Deadline, want, start)
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	var tail tailBuffer
+	tail.Write([]byte("start\n"))
+	tail.Write([]byte(strings.Repeat("x", maxOutputSize-1) + "\n"))
+	got := tail.String()
+	if len(got) != maxOutputSize || strings.HasPrefix(got, "start") || !strings.HasSuffix(got, "x\n") {
+		t.Errorf("tail kept %d bytes starting with %q", len(got), got[:10])
+	}
+	if tail.lastWrite.Load() == 0 {
+		t.Error("last write not recorded")
+	}
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"pkbldr/activities"
+	"pkbldr/builds"
+	"pkbldr/config"
+	"pkbldr/events"
+	"pkbldr/packages"
+	"pkbldr/repo"
+	"pkbldr/scheduler"
+	"pkbldr/starters"
+	"pkbldr/workflows"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Default and largest number of entries the list endpoints return
+const (
+	apiPageSize    = 100
+	apiMaxPageSize = 1000
+)
+
+// pagination reads the limit and offset query parameters of the list
+// endpoints. Larger limits are capped at apiMaxPageSize.
+func pagination(c *fiber.Ctx) (int, int) {
+	limit := c.QueryInt("limit", apiPageSize)
+	if limit < 1 {
+		limit = apiPageSize
+	}
+	if limit > apiMaxPageSize {
+		limit = apiMaxPageSize
+	}
+	offset := c.QueryInt("offset", 0)
+	if offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
+// apiEventsHandler returns the event log, optionally limited to one package.
+func apiEventsHandler(c *fiber.Ctx) error {
+	packageFilter := c.Query("package", "")
+	limit, offset := pagination(c)
+
+	var eventList []events.Event
+	var err error
+	if packageFilter != "" {
+		eventList, err = events.ForPackage(packageFilter, limit, offset)
+	} else {
+		eventList, err = events.Recent(limit, offset)
+	}
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+	if eventList == nil {
+		eventList = []events.Event{}
+	}
+	return c.JSON(eventList)
+}
+
+// apiBuildsHandler returns the build records, optionally limited to one
+// source package.
+func apiBuildsHandler(c *fiber.Ctx) error {
+	sourceFilter := c.Query("source", "")
+	limit, offset := pagination(c)
+
+	var buildList []builds.Build
+	var err error
+	if sourceFilter != "" {
+		buildList, err = builds.ForSource(sourceFilter, limit, offset)
+	} else {
+		buildList, err = builds.Recent(limit, offset)
+	}
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+	if buildList == nil {
+		buildList = []builds.Build{}
+	}
+	return c.JSON(buildList)
+}
+
+// apiBuildManifestHandler serves the signed manifest of a build as a file.
+func apiBuildManifestHandler(c *fiber.Ctx) error {
+	key, err := url.PathUnescape(c.Params("key"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	build, err := builds.Get(key)
+	if errors.Is(err, builds.ErrUnknownBuild) {
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+	if build.Manifest == "" {
+		return fiber.NewError(fiber.StatusNotFound, "build has no manifest")
+	}
+	c.Attachment(build.Source + "_" + build.Version + ".manifest.asc")
+	c.Set(fiber.HeaderContentType, fiber.MIMETextPlainCharsetUTF8)
+	return c.SendString(build.Manifest)
+}
+
+func apiPromotionsHandler(c *fiber.Ctx) error {
+	status := repo.PromotionStatus(c.Query("status", string(repo.Staged)))
+	promotions, err := repo.ListPromotions(status)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+	if promotions == nil {
+		promotions = []repo.Promotion{}
+	}
+	return c.JSON(promotions)
+}
+
+func apiPromoteHandler(c *fiber.Ctx) error {
+	var request struct {
+		Source  string `json:"source"`
+		Version string `json:"version"`
+	}
+	err := c.BodyParser(&request)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	err = promote(c, request.Source, request.Version, "promoted through the API")
+	if err != nil {
+		return err
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// promote promotes a staged build where the repository is, with Temporal
+// that is a build worker.
+func promote(c *fiber.Ctx, source, version, reason string) error {
+	var err error
+	if config.Configs.BuiltinScheduler() {
+		err = repo.Promote(source, version, currentActor(c), reason)
+	} else if temporalClient == nil {
+		return errNoTemporal
+	} else {
+		err = starters.PromoteBuild(c.Context(), temporalClient, activities.PromoteRequest{
+			Source:  source,
+			Version: version,
+			Actor:   currentActor(c),
+			Reason:  reason,
+		})
+	}
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	return nil
+}
+
+// errNoTemporal is returned by the build run controls with the builtin
+// scheduler.
+var errNoTemporal = fiber.NewError(fiber.StatusServiceUnavailable, "build runs can only be controlled with the Temporal scheduler")
+
+// apiRunHandler returns the state of the running build run.
+func apiRunHandler(c *fiber.Ctx) error {
+	if temporalClient == nil {
+		return errNoTemporal
+	}
+	run, err := starters.GetBuildRun(c.Context(), temporalClient)
+	if errors.Is(err, starters.ErrNoBuildRun) {
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(run)
+}
+
+func apiRunSignalHandler(c *fiber.Ctx) error {
+	err := signalBuildRun(c)
+	if err != nil {
+		return err
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// signalBuildRun sends the signal named in the route to the running build
+// run, cancel and prioritize take the source from the form.
+func signalBuildRun(c *fiber.Ctx) error {
+	if temporalClient == nil {
+		return errNoTemporal
+	}
+	var arg interface{}
+	signal := c.Params("signal")
+	switch signal {
+	case workflows.SignalPause, workflows.SignalResume:
+	case workflows.SignalCancel, workflows.SignalPrioritize:
+		source := c.FormValue("source")
+		if source == "" {
+			return fiber.NewError(fiber.StatusBadRequest, "source is required")
+		}
+		arg = workflows.SourceSignal{Source: source, Actor: currentActor(c)}
+	default:
+		return fiber.NewError(fiber.StatusNotFound, "unknown signal "+signal)
+	}
+
+	err := starters.SignalBuildRun(c.Context(), temporalClient, signal, arg)
+	if errors.Is(err, starters.ErrNoBuildRun) {
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+	return nil
+}
+
+// apiSchedulesHandler returns the state of the schedules.
+func apiSchedulesHandler(c *fiber.Ctx) error {
+	schedules, err := listSchedules(c)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(schedules)
+}
+
+// listSchedules returns the schedules of the scheduler in use.
+func listSchedules(c *fiber.Ctx) ([]starters.ScheduleStatus, error) {
+	if config.Configs.BuiltinScheduler() {
+		return scheduler.ListSchedules()
+	}
+	return starters.ListSchedules(temporalClient, c.Context())
+}
+
+func apiScheduleActionHandler(c *fiber.Ctx) error {
+	err := runScheduleAction(c)
+	if err != nil {
+		return err
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// runScheduleAction pauses, unpauses or triggers the schedule named in the
+// route.
+func runScheduleAction(c *fiber.Ctx) error {
+	var err error
+	name := c.Params("name")
+	builtin := config.Configs.BuiltinScheduler()
+	switch c.Params("action") {
+	case "pause":
+		if builtin {
+			err = scheduler.PauseSchedule(name, currentActor(c))
+		} else {
+			err = starters.PauseSchedule(temporalClient, c.Context(), name, currentActor(c))
+		}
+	case "unpause":
+		if builtin {
+			err = scheduler.UnpauseSchedule(name, currentActor(c))
+		} else {
+			err = starters.UnpauseSchedule(temporalClient, c.Context(), name, currentActor(c))
+		}
+	case "trigger":
+		if builtin {
+			err = scheduler.TriggerSchedule(name, currentActor(c))
+		} else {
+			err = starters.TriggerSchedule(temporalClient, c.Context(), name, currentActor(c))
+		}
+	default:
+		return fiber.NewError(fiber.StatusNotFound, "unknown action "+c.Params("action"))
+	}
+	if errors.Is(err, starters.ErrUnknownSchedule) {
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+	return nil
+}
+
+// Lines returned from a build log when none or an invalid number is asked,
+// and the most returned
+const (
+	defaultLogLines = 100
+	maxLogLines     = 10000
+)
+
+// logLines reads the lines query parameter of the build log endpoint.
+func logLines(c *fiber.Ctx) int {
+	lines := c.QueryInt("lines", defaultLogLines)
+	if lines < 1 {
+		return defaultLogLines
+	}
+	if lines > maxLogLines {
+		return maxLogLines
+	}
+	return lines
+}
+
+// apiPackagesHandler returns the packages, optionally filtered by status,
+// name and hold.
+func apiPackagesHandler(c *fiber.Ctx) error {
+	statusFilter := c.Query("status", "all")
+	nameFilter := c.Query("name", "")
+	heldOnly := c.QueryBool("held", false)
+	limit, offset := pagination(c)
+
+	var pkgs []packages.PackageInfo
+	if statusFilter == "all" || statusFilter == "" {
+		pkgs = packages.GetPackagesSlice()
+	} else if status, ok := packages.ParsePackageStatus(statusFilter); ok {
+		pkgs = packages.GetPackagesByStatus(status)
+	} else {
+		return fiber.NewError(fiber.StatusBadRequest, "unknown status "+statusFilter)
+	}
+
+	matched := make([]packages.PackageInfo, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		if heldOnly && !pkg.Held {
+			continue
+		}
+		if nameFilter == "" || strings.Contains(pkg.Name, nameFilter) {
+			matched = append(matched, pkg)
+		}
+	}
+	if offset > len(matched) {
+		offset = len(matched)
+	}
+	end := offset + limit
+	if end > len(matched) {
+		end = len(matched)
+	}
+	return c.JSON(matched[offset:end])
+}
+
+func apiPackageHandler(c *fiber.Ctx) error {
+	pkg, ok := packages.GetPackage(c.Params("name"))
+	if !ok {
+		return fiber.NewError(fiber.StatusNotFound, "unknown package "+c.Params("name"))
+	}
+	return c.JSON(pkg)
+}
+
+// apiHoldHandler holds a package, keeping its source out of the build
+// queue.
+func apiHoldHandler(c *fiber.Ctx) error {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if len(c.Body()) > 0 {
+		err := c.BodyParser(&body)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+	}
+	return setHold(c, true, body.Reason)
+}
+
+func apiUnholdHandler(c *fiber.Ctx) error {
+	return setHold(c, false, "released")
+}
+
+func setHold(c *fiber.Ctx, held bool, reason string) error {
+	err := packages.SetHold(c.Params("name"), held, currentActor(c), reason)
+	if errors.Is(err, packages.ErrUnknownPackage) {
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// apiRebuildHandler marks the source of a package for another build and
+// starts building it.
+func apiRebuildHandler(c *fiber.Ctx) error {
+	err := packages.RequestRebuild(c.Params("name"), currentActor(c))
+	if errors.Is(err, packages.ErrUnknownPackage) {
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+	err = triggerBuild(c)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+	return c.SendStatus(fiber.StatusAccepted)
+}
+
+// triggerBuild hands the build queue to the open build run, or starts one
+// when there is none.
+func triggerBuild(c *fiber.Ctx) error {
+	if config.Configs.BuiltinScheduler() {
+		return scheduler.TriggerSchedule(config.ScheduleBuild, currentActor(c))
+	}
+	err := starters.SignalBuildRun(c.Context(), temporalClient, workflows.SignalEnqueue, nil)
+	if errors.Is(err, starters.ErrNoBuildRun) {
+		return starters.TriggerSchedule(temporalClient, c.Context(), config.ScheduleBuild, currentActor(c))
+	}
+	return err
+}
+
+// apiBuildLogHandler returns the end of the newest build log of a package.
+func apiBuildLogHandler(c *fiber.Ctx) error {
+	lines := logLines(c)
+	pkg, ok := packages.GetPackage(c.Params("name"))
+	if !ok {
+		return fiber.NewError(fiber.StatusNotFound, packages.ErrUnknownPackage.Error())
+	}
+	// The build logs are on the build workers, with Temporal the server may
+	// run elsewhere
+	var log activities.BuildLog
+	var err error
+	if config.Configs.BuiltinScheduler() {
+		log, err = activities.TailBuildLog(c.Context(), pkg.Name, lines)
+	} else if temporalClient == nil {
+		return errNoTemporal
+	} else {
+		log, err = starters.ReadBuildLog(c.Context(), temporalClient, pkg.Name, lines)
+	}
+	if errors.Is(err, activities.ErrNoBuildLog) {
+		return fiber.NewError(fiber.StatusNotFound, activities.ErrNoBuildLog.Error())
+	}
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
+	c.Set("X-Build-Log", log.File)
+	return c.SendString(strings.Join(log.Lines, "\n") + "\n")
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestPagination(t *testing.T) {
+	tests := []struct {
+		query      string
+		wantLimit  int
+		wantOffset int
+	}{
+		{"", apiPageSize, 0},
+		{"?limit=20&offset=40", 20, 40},
+		{"?limit=0", apiPageSize, 0},
+		{"?limit=-5", apiPageSize, 0},
+		{"?limit=1000000", apiMaxPageSize, 0},
+		{"?offset=-10", apiPageSize, 0},
+		{"?limit=abc&offset=xyz", apiPageSize, 0},
+	}
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		limit, offset := pagination(c)
+		return c.SendString(strconv.Itoa(limit) + "," + strconv.Itoa(offset))
+	})
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			resp, err := app.Test(httptest.NewRequest("GET", "/"+tt.query, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := strconv.Itoa(tt.wantLimit) + "," + strconv.Itoa(tt.wantOffset)
+			if string(body) != want {
+				t.Errorf("pagination() = %s, want %s", body, want)
+			}
+		})
+	}
+}
+
+func TestLogLines(t *testing.T) {
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"", defaultLogLines},
+		{"?lines=20", 20},
+		{"?lines=0", defaultLogLines},
+		{"?lines=-5", defaultLogLines},
+		{"?lines=abc", defaultLogLines},
+		{"?lines=1000000", maxLogLines},
+	}
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString(strconv.Itoa(logLines(c)))
+	})
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			resp, err := app.Test(httptest.NewRequest("GET", "/"+tt.query, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != strconv.Itoa(tt.want) {
+				t.Errorf("logLines() = %s, want %d", body, tt.want)
+			}
+		})
+	}
+}
@@ -71,8 +71,13 @@ func GenerateAndStoreSessionToken(username string) (string, error) {
 		return "", err
 	}
 
-	sessionCache.Set(string(token), username)
-	return base64.RawURLEncoding.EncodeToString(token), nil
+	encodedToken := base64.RawURLEncoding.EncodeToString(token)
+	sessionCache.Set(encodedToken, username)
+	return encodedToken, nil
+}
+
+func RemoveSessionToken(token string) {
+	sessionCache.Delete(token)
 }
 
 func CheckSessionToken(token string) (bool, string) {
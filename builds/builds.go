@@ -0,0 +1,202 @@
+package builds
+
+import (
+	"errors"
+	"pkbldr/db"
+	"pkbldr/metrics"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/surrealdb/surrealdb.go"
+)
+
+var dbInstance *surrealdb.DB
+var dbLock sync.Mutex
+
+var ErrUnknownBuild = errors.New("unknown build")
+
+type Outcome string
+
+const (
+	// Build is still running
+	Running   Outcome = "Running"
+	Succeeded Outcome = "Succeeded"
+	Failed    Outcome = "Failed"
+	Cancelled Outcome = "Cancelled"
+)
+
+type TestStatus string
+
+const (
+	// Tests were disabled or the source ships none
+	TestsNotRun  TestStatus = ""
+	TestsPassed  TestStatus = "passed"
+	TestsSkipped TestStatus = "skipped"
+	TestsFailed  TestStatus = "failed"
+	// The test runner itself failed
+	TestsError TestStatus = "error"
+)
+
+// TestResult is the outcome of a test suite run against a build.
+type TestResult struct {
+	Runner   string     `json:"runner"`
+	Status   TestStatus `json:"status"`
+	ExitCode int        `json:"exitcode"`
+	// Tail of the runner output
+	Output string `json:"output"`
+}
+
+type ReproStatus string
+
+const (
+	// No second build was made
+	ReproNotChecked ReproStatus = ""
+	Reproducible    ReproStatus = "reproducible"
+	Unreproducible  ReproStatus = "unreproducible"
+	// The second build failed, nothing could be compared
+	ReproError ReproStatus = "error"
+	// The first build recorded no checksums to compare with
+	ReproUnknown ReproStatus = "unknown"
+)
+
+// Reproducibility is the result of comparing a build with a second build of
+// the same source.
+type Reproducibility struct {
+	Status      ReproStatus `json:"status"`
+	Differences []string    `json:"differences"`
+}
+
+// Build is the record of a single build attempt of a source package.
+type Build struct {
+	ID       string   `json:"id"`
+	Source   string   `json:"source"`
+	Version  string   `json:"version"`
+	Packages []string `json:"packages"`
+	Worker   string   `json:"worker"`
+	Outcome  Outcome  `json:"outcome"`
+	Reason   string   `json:"reason"`
+	// File names of the published .debs
+	Artifacts []string `json:"artifacts"`
+	// sha256 of the published .debs by file name
+	ArtifactHashes  map[string]string `json:"artifacthashes"`
+	Autopkgtest     TestResult        `json:"autopkgtest"`
+	Reproducibility Reproducibility   `json:"reproducibility"`
+	// Build command, or the upstream fallback
+	Strategy string `json:"strategy"`
+	// Image ID and generation tag of the builder container
+	BuilderImage string `json:"builderimage"`
+	BuilderTag   string `json:"buildertag"`
+	// Signed manifest of the inputs and outputs
+	Manifest   string    `json:"manifest"`
+	StartedAt  time.Time `json:"startedat"`
+	FinishedAt time.Time `json:"finishedat"`
+}
+
+func connect() error {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+	if dbInstance != nil {
+		return nil
+	}
+	var err error
+	dbInstance, err = db.New()
+	return err
+}
+
+// New starts the record of a build, it isn't stored until Save.
+func New(source, version string, packages []string, worker string) *Build {
+	start := time.Now().UTC()
+	return &Build{
+		ID:        "builds:`" + source + "_" + version + "_" + strconv.FormatInt(start.UnixNano(), 10) + "`",
+		Source:    source,
+		Version:   version,
+		Packages:  packages,
+		Worker:    worker,
+		Outcome:   Running,
+		StartedAt: start,
+	}
+}
+
+func Save(build *Build) error {
+	defer metrics.ObserveSince(metrics.DBWriteDuration.WithLabelValues("build"), time.Now())
+	err := connect()
+	if err != nil {
+		return err
+	}
+	_, err = surrealdb.SmartMarshal(dbInstance.Update, *build)
+	return err
+}
+
+// Finish stores the final outcome of a build.
+func Finish(build *Build, outcome Outcome, reason string) error {
+	build.Outcome = outcome
+	build.Reason = reason
+	build.FinishedAt = time.Now().UTC()
+	return Save(build)
+}
+
+// Key returns the record id without the table name, as used in URLs.
+func (b Build) Key() string {
+	key := strings.TrimPrefix(b.ID, "builds:")
+	key = strings.TrimPrefix(key, "⟨")
+	key = strings.TrimSuffix(key, "⟩")
+	return strings.Trim(key, "`")
+}
+
+// Get returns the build with the given key.
+func Get(key string) (Build, error) {
+	err := connect()
+	if err != nil {
+		return Build{}, err
+	}
+	data, err := dbInstance.Select("builds:`" + key + "`")
+	build, err := db.Record[Build](data, err)
+	if errors.Is(err, db.ErrNotFound) {
+		return build, ErrUnknownBuild
+	}
+	return build, err
+}
+
+// ForSource returns the newest builds of a source package.
+func ForSource(source string, limit int, offset int) ([]Build, error) {
+	err := connect()
+	if err != nil {
+		return nil, err
+	}
+	return surrealdb.SmartUnmarshal[[]Build](dbInstance.Query(
+		"SELECT * FROM builds WHERE source = $source ORDER BY startedat DESC LIMIT $limit START $offset",
+		map[string]interface{}{
+			"source": source,
+			"limit":  limit,
+			"offset": offset,
+		}))
+}
+
+// Recent returns the newest builds across all sources.
+func Recent(limit int, offset int) ([]Build, error) {
+	err := connect()
+	if err != nil {
+		return nil, err
+	}
+	return surrealdb.SmartUnmarshal[[]Build](dbInstance.Query(
+		"SELECT * FROM builds ORDER BY startedat DESC LIMIT $limit START $offset",
+		map[string]interface{}{
+			"limit":  limit,
+			"offset": offset,
+		}))
+}
+
+// Unfinished returns the builds still marked as running.
+func Unfinished() ([]Build, error) {
+	err := connect()
+	if err != nil {
+		return nil, err
+	}
+	return surrealdb.SmartUnmarshal[[]Build](dbInstance.Query(
+		"SELECT * FROM builds WHERE outcome = $outcome",
+		map[string]interface{}{
+			"outcome": Running,
+		}))
+}
@@ -0,0 +1,56 @@
+package builds
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// Artifact is a single output file of a build.
+type Artifact struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest describes what went into a build and what came out of it.
+type Manifest struct {
+	Source  string `json:"source"`
+	Version string `json:"version"`
+	// The .dsc fetched by apt-get source
+	Dsc       string `json:"dsc"`
+	DscSHA256 string `json:"dscSha256"`
+	// Image ID of the builder container the build ran in
+	BuilderImage string     `json:"builderImage"`
+	BuilderTag   string     `json:"builderTag"`
+	Strategy     string     `json:"strategy"`
+	Worker       string     `json:"worker"`
+	Artifacts    []Artifact `json:"artifacts"`
+	CreatedAt    time.Time  `json:"createdAt"`
+}
+
+// Sign renders the manifest as JSON and clearsigns it with the given gpg key.
+// Without a key the manifest is returned unsigned.
+func Sign(manifest Manifest, key string) (string, error) {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	data = append(data, '\n')
+	if key == "" {
+		return string(data), nil
+	}
+
+	var signed bytes.Buffer
+	var stderr bytes.Buffer
+	cmd := exec.Command("gpg", "--batch", "--yes", "--local-user", key, "--clearsign")
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &signed
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("unable to sign manifest: %w: %s", err, stderr.String())
+	}
+	return signed.String(), nil
+}
@@ -0,0 +1,353 @@
+// Command pkbldrctl controls a pkbldr server through its API.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"pkbldr/events"
+	"pkbldr/packages"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `Usage: pkbldrctl [flags] command [arguments]
+
+Commands:
+  login -user name          print a session token, the password is read from
+                            PKBLDR_PASSWORD or stdin
+  packages [-status s] [-name n] [-held]
+                            list packages
+  show package              show a package and its status history
+  history package           show the event log of a package
+  fetch                     fetch the package indexes now
+  rebuild package           build the source of a package again
+  log [-n lines] package    print the end of the newest build log
+  hold [-reason r] package  keep the source of a package out of the build queue
+  unhold package            release a held package
+
+Flags:
+`
+
+const timeLayout = "02-01-2006 15:04:05"
+
+// client talks to the server API.
+type client struct {
+	url   string
+	token string
+	json  bool
+	http  *http.Client
+}
+
+func main() {
+	flags := flag.NewFlagSet("pkbldrctl", flag.ExitOnError)
+	serverURL := flags.String("url", envOr("PKBLDR_URL", "http://localhost:7555"), "server URL, defaults to $PKBLDR_URL")
+	token := flags.String("token", os.Getenv("PKBLDR_TOKEN"), "session token, defaults to $PKBLDR_TOKEN")
+	jsonOutput := flags.Bool("json", false, "print JSON instead of tables")
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[1:])
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	c := &client{
+		url:   strings.TrimRight(*serverURL, "/"),
+		token: *token,
+		json:  *jsonOutput,
+		http:  &http.Client{Timeout: time.Minute},
+	}
+	err := run(c, flags.Arg(0), flags.Args()[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "pkbldrctl: "+err.Error())
+		os.Exit(1)
+	}
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func run(c *client, command string, args []string) error {
+	switch command {
+	case "login":
+		return c.login(args)
+	case "packages":
+		return c.packages(args)
+	case "show":
+		return c.show(args)
+	case "history":
+		return c.history(args)
+	case "fetch":
+		return c.post("/api/schedules/fetch/trigger", nil)
+	case "rebuild":
+		return c.packageAction("rebuild", args, nil)
+	case "log":
+		return c.log(args)
+	case "hold":
+		flags := flag.NewFlagSet("hold", flag.ContinueOnError)
+		reason := flags.String("reason", "", "why the package is held")
+		err := flags.Parse(args)
+		if err != nil {
+			return err
+		}
+		return c.packageAction("hold", flags.Args(), map[string]string{"reason": *reason})
+	case "unhold":
+		return c.packageAction("unhold", args, nil)
+	}
+	return fmt.Errorf("unknown command %s, see pkbldrctl -h", command)
+}
+
+// do sends a request and returns the response body, failed requests return
+// the error message of the server.
+func (c *client) do(method, path string, body interface{}) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.url+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// get fetches a JSON document into out, or prints it with -json.
+func (c *client) get(path string, out interface{}) (bool, error) {
+	data, err := c.do(http.MethodGet, path, nil)
+	if err != nil {
+		return false, err
+	}
+	if c.json {
+		var indented bytes.Buffer
+		err = json.Indent(&indented, data, "", "  ")
+		if err != nil {
+			return false, err
+		}
+		fmt.Println(indented.String())
+		return true, nil
+	}
+	return false, json.Unmarshal(data, out)
+}
+
+func (c *client) post(path string, body interface{}) error {
+	_, err := c.do(http.MethodPost, path, body)
+	return err
+}
+
+func (c *client) login(args []string) error {
+	flags := flag.NewFlagSet("login", flag.ContinueOnError)
+	username := flags.String("user", os.Getenv("USER"), "username")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	password := os.Getenv("PKBLDR_PASSWORD")
+	if password == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return err
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	data, err := c.do(http.MethodPost, "/api/login", map[string]string{
+		"username": *username,
+		"password": password,
+	})
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Token string `json:"token"`
+	}
+	err = json.Unmarshal(data, &resp)
+	if err != nil {
+		return err
+	}
+	fmt.Println(resp.Token)
+	return nil
+}
+
+func (c *client) packages(args []string) error {
+	flags := flag.NewFlagSet("packages", flag.ContinueOnError)
+	status := flags.String("status", "all", "only packages in this status")
+	name := flags.String("name", "", "only packages whose name contains this")
+	held := flags.Bool("held", false, "only held packages")
+	limit := flags.Int("limit", 100, "number of packages")
+	offset := flags.Int("offset", 0, "number of packages to skip")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	query.Set("status", *status)
+	query.Set("name", *name)
+	query.Set("held", strconv.FormatBool(*held))
+	query.Set("limit", strconv.Itoa(*limit))
+	query.Set("offset", strconv.Itoa(*offset))
+	var pkgs []packages.PackageInfo
+	printed, err := c.get("/api/packages?"+query.Encode(), &pkgs)
+	if err != nil || printed {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVERSION\tNEW VERSION\tSTATUS\tLAST BUILD\tHELD")
+	for _, pkg := range pkgs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", pkg.Name, pkg.Version, pkg.PendingVersion, pkg.Status, pkg.LastBuildStatus, heldColumn(pkg))
+	}
+	return w.Flush()
+}
+
+func heldColumn(pkg packages.PackageInfo) string {
+	if !pkg.Held {
+		return ""
+	}
+	if pkg.HoldReason == "" {
+		return "yes"
+	}
+	return "yes (" + pkg.HoldReason + ")"
+}
+
+// packageName returns the single package argument of a command.
+func packageName(command string, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("%s takes exactly one package", command)
+	}
+	return args[0], nil
+}
+
+func (c *client) show(args []string) error {
+	name, err := packageName("show", args)
+	if err != nil {
+		return err
+	}
+	var pkg packages.PackageInfo
+	printed, err := c.get("/api/packages/"+url.PathEscape(name), &pkg)
+	if err != nil || printed {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", pkg.Name)
+	fmt.Fprintf(w, "Source:\t%s\n", pkg.Source)
+	fmt.Fprintf(w, "Version:\t%s\n", pkg.Version)
+	fmt.Fprintf(w, "New version:\t%s\n", pkg.PendingVersion)
+	fmt.Fprintf(w, "Status:\t%s\n", pkg.Status)
+	fmt.Fprintf(w, "Last build:\t%s %s\n", pkg.LastBuildStatus, pkg.LastBuildVersion)
+	if pkg.LastBuildError != "" {
+		fmt.Fprintf(w, "Last error:\t%s\n", pkg.LastBuildError)
+	}
+	fmt.Fprintf(w, "Held:\t%s\n", heldColumn(pkg))
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "TIME\tFROM\tTO\tEVENT")
+	for _, transition := range pkg.StatusHistory {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", transition.Time.Local().Format(timeLayout), transition.From, transition.To, transition.Event)
+	}
+	return w.Flush()
+}
+
+func (c *client) history(args []string) error {
+	flags := flag.NewFlagSet("history", flag.ContinueOnError)
+	limit := flags.Int("limit", 50, "number of events")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	name, err := packageName("history", flags.Args())
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	query.Set("package", name)
+	query.Set("limit", strconv.Itoa(*limit))
+	var eventList []events.Event
+	printed, err := c.get("/api/events?"+query.Encode(), &eventList)
+	if err != nil || printed {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tKIND\tACTOR\tCHANGE\tREASON")
+	for _, event := range eventList {
+		change := event.Field
+		if event.Before != "" || event.After != "" {
+			change += " " + event.Before + " -> " + event.After
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", event.Time.Local().Format(timeLayout), event.Kind, event.Actor.String(), change, event.Reason)
+	}
+	return w.Flush()
+}
+
+func (c *client) log(args []string) error {
+	flags := flag.NewFlagSet("log", flag.ContinueOnError)
+	lines := flags.Int("n", 100, "number of lines")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	name, err := packageName("log", flags.Args())
+	if err != nil {
+		return err
+	}
+	data, err := c.do(http.MethodGet, "/api/packages/"+url.PathEscape(name)+"/log?lines="+strconv.Itoa(*lines), nil)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+// packageAction posts an action on a single package.
+func (c *client) packageAction(action string, args []string, body interface{}) error {
+	name, err := packageName(action, args)
+	if err != nil {
+		return err
+	}
+	return c.post("/api/packages/"+url.PathEscape(name)+"/"+action, body)
+}
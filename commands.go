@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"pkbldr/activities"
+	"pkbldr/config"
+	"pkbldr/events"
+	"pkbldr/packages"
+	"pkbldr/scheduler"
+	"pkbldr/starters"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/client"
+)
+
+const usage = `Usage: pkbldr [command] [flags]
+
+Commands:
+  all           run the web server, the workers and the schedules (default)
+  serve         run the web server
+  worker        run Temporal workers, --queues selects the task queues and
+                --metrics serves /metrics on its own address
+  schedule sync create or update the schedules from the configuration
+  fetch-once    fetch the package indexes once
+  build-once    build the build queue once
+
+With Temporal the web server needs no access to the repository or the build
+logs, it promotes builds and reads logs through the build workers. Every
+build and source worker must share Repo.Dir, the deb output directory and
+the build log directory.
+`
+
+var errNoWorkers = errors.New("workers need the Temporal scheduler, the builtin scheduler runs jobs in the serve process")
+
+// runCommand runs the subcommand named by the first argument.
+func runCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return runAll(ctx, args)
+	}
+	switch args[0] {
+	case "all":
+		return runAll(ctx, args[1:])
+	case "serve":
+		return runServe(ctx, args[1:])
+	case "worker":
+		return runWorker(ctx, args[1:])
+	case "schedule":
+		return runSchedule(ctx, args[1:])
+	case "fetch-once":
+		return runFetchOnce(ctx, args[1:])
+	case "build-once":
+		return runBuildOnce(ctx, args[1:])
+	case "help", "-h", "--help":
+		fmt.Fprint(os.Stderr, usage)
+		return nil
+	}
+	fmt.Fprint(os.Stderr, usage)
+	return fmt.Errorf("unknown command %s", args[0])
+}
+
+// newFlagSet returns the flag set of a subcommand.
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: pkbldr %s [flags]\n", name)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// setup loads the configuration and the package store every command needs.
+func setup() error {
+	err := config.Init()
+	if err != nil {
+		slog.Error("unable to load configuration: " + err.Error())
+		return err
+	}
+
+	err = events.TrackConfig(events.SchedulerActor())
+	if err != nil {
+		slog.Error("unable to track configuration changes: " + err.Error())
+	}
+
+	err = packages.LoadFromDb()
+	if err != nil {
+		slog.Error("unable to load packages from db: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+func dialTemporal() (client.Client, error) {
+	c, err := client.Dial(client.Options{
+		HostPort: config.Configs.TemporalUrl,
+	})
+	if err != nil {
+		slog.Error("unable to create Temporal client: " + err.Error())
+		return nil, err
+	}
+	temporalClient = c
+	activities.SetTemporalClient(c)
+	return c, nil
+}
+
+func syncSchedules(ctx context.Context, c client.Client) {
+	err := starters.SyncSchedules(c, ctx)
+	if err != nil {
+		fmt.Println("unable to sync schedules", err)
+	} else {
+		fmt.Println("schedules synced")
+	}
+}
+
+// reconcileBuilds resets what a crashed process left queued or building.
+// Temporal must be dialed already to see the open build run. Only processes
+// running builds reconcile, the web server alone can't tell builds on other
+// hosts from orphans.
+func reconcileBuilds(ctx context.Context) {
+	result, err := activities.ReconcileBuilds(ctx)
+	if err != nil {
+		slog.Error("unable to reconcile interrupted builds: " + err.Error())
+		return
+	}
+	if result.Owned {
+		fmt.Println("a build is running, leaving in-progress packages to it")
+		return
+	}
+	fmt.Printf("reconciled builds: %d packages reset, %d containers removed, %d build records closed\n", len(result.Reset), result.Containers, result.Builds)
+}
+
+// background runs fn in a goroutine, the returned function waits for it to
+// return.
+func background(fn func()) func() {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+	return func() { <-done }
+}
+
+// serveUntilDone runs the web server until ctx ends, then waits for the
+// background work to wind down.
+func serveUntilDone(ctx context.Context, waits ...func()) error {
+	err := runServer(ctx)
+	if ctx.Err() == nil {
+		// The server failed on its own
+		return err
+	}
+	for _, wait := range waits {
+		wait()
+	}
+	return err
+}
+
+// runAll runs everything in one process.
+func runAll(ctx context.Context, args []string) error {
+	flags := newFlagSet("all")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	err = setup()
+	if err != nil {
+		return err
+	}
+
+	if config.Configs.BuiltinScheduler() {
+		reconcileBuilds(ctx)
+		wait := background(func() { scheduler.Run(ctx) })
+		return serveUntilDone(ctx, wait)
+	}
+
+	c, err := dialTemporal()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	reconcileBuilds(ctx)
+	wait := background(func() {
+		err := runWorkers(ctx, c, allQueues)
+		if err != nil {
+			slog.Error(err.Error())
+		}
+	})
+	go syncSchedules(ctx, c)
+	return serveUntilDone(ctx, wait)
+}
+
+// How often serve reloads the package store, with Temporal the workers
+// update the packages in processes of their own
+const storeRefreshInterval = 30 * time.Second
+
+// refreshPackages reloads the package store until ctx is cancelled.
+func refreshPackages(ctx context.Context) {
+	ticker := time.NewTicker(storeRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			packages.LoadFromDb()
+		}
+	}
+}
+
+// runServe runs the web server. With the builtin scheduler the jobs run in
+// the same process.
+func runServe(ctx context.Context, args []string) error {
+	flags := newFlagSet("serve")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	err = setup()
+	if err != nil {
+		return err
+	}
+
+	if config.Configs.BuiltinScheduler() {
+		// The builds run in this process
+		reconcileBuilds(ctx)
+		wait := background(func() { scheduler.Run(ctx) })
+		return serveUntilDone(ctx, wait)
+	}
+
+	c, err := dialTemporal()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	go refreshPackages(ctx)
+	return serveUntilDone(ctx)
+}
+
+func runWorker(ctx context.Context, args []string) error {
+	flags := newFlagSet("worker")
+	queues := flags.String("queues", strings.Join(allQueues, ","), "comma separated task queues to serve")
+	metricsAddr := flags.String("metrics", "", "address to serve /metrics on, e.g. :9100")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	err = setup()
+	if err != nil {
+		return err
+	}
+	if config.Configs.BuiltinScheduler() {
+		return errNoWorkers
+	}
+
+	c, err := dialTemporal()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	reconcileBuilds(ctx)
+	if *metricsAddr != "" {
+		go serveMetrics(ctx, *metricsAddr)
+	}
+	return runWorkers(ctx, c, strings.Split(*queues, ","))
+}
+
+func runSchedule(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] != "sync" {
+		fmt.Fprint(os.Stderr, usage)
+		return errors.New("schedule needs a subcommand, sync is the only one")
+	}
+	flags := newFlagSet("schedule sync")
+	err := flags.Parse(args[1:])
+	if err != nil {
+		return err
+	}
+	err = setup()
+	if err != nil {
+		return err
+	}
+	if config.Configs.BuiltinScheduler() {
+		return scheduler.SyncSchedules()
+	}
+
+	c, err := dialTemporal()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return starters.SyncSchedules(c, ctx)
+}
+
+func runFetchOnce(ctx context.Context, args []string) error {
+	flags := newFlagSet("fetch-once")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	err = setup()
+	if err != nil {
+		return err
+	}
+
+	summary, err := activities.FetchPackages(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("fetch done, %d packages became stale and %d missing\n", len(summary.Stale), len(summary.Missing))
+	return nil
+}
+
+// runBuildOnce builds the build queue in this process, it needs Docker.
+func runBuildOnce(ctx context.Context, args []string) error {
+	flags := newFlagSet("build-once")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	err = setup()
+	if err != nil {
+		return err
+	}
+
+	if config.Configs.BuiltinScheduler() {
+		reconcileBuilds(ctx)
+	} else {
+		// Only the open build run tells whether the Temporal workers are
+		// building the in-progress packages
+		c, err := dialTemporal()
+		if err == nil {
+			defer c.Close()
+			reconcileBuilds(ctx)
+		}
+	}
+
+	err = activities.UpdateDockerContainer(ctx)
+	if err != nil {
+		return err
+	}
+	return activities.StartBuildLoop(ctx)
+}
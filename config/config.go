@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 )
 
 var Users map[string]User
@@ -28,6 +29,116 @@ type PackageFile struct {
 	Compression  string   `json:"compression"`
 }
 
+// Struct for the APT repository generated from the build outputs
+type RepoConfig struct {
+	Enabled       bool     `json:"enabled"`
+	Dir           string   `json:"dir"`
+	Origin        string   `json:"origin"`
+	Label         string   `json:"label"`
+	Suite         string   `json:"suite"`
+	Codename      string   `json:"codename"`
+	Component     string   `json:"component"`
+	Description   string   `json:"description"`
+	Architectures []string `json:"architectures"`
+	Compression   []string `json:"compression"`
+	SigningKey    string   `json:"signingKey"`
+	// Land builds in a staging suite and promote them to Suite later, they
+	// are copied to DeboutputDir only when promoted
+	Staging      bool   `json:"staging"`
+	StagingSuite string `json:"stagingSuite"`
+	// How long a build stays staged before automatic promotion, empty
+	// means no promotion after a soak period
+	SoakPeriod string `json:"soakPeriod"`
+	// Promote builds as soon as their install check passed
+	PromoteOnInstallCheck bool `json:"promoteOnInstallCheck"`
+	// Refuse to promote builds whose autopkgtests failed
+	GateOnAutopkgtest bool `json:"gateOnAutopkgtest"`
+}
+
+// Struct for the autopkgtest post build stage
+type AutopkgtestConfig struct {
+	Enabled bool `json:"enabled"`
+	// Virtualisation server, "null" runs the tests directly in the builder
+	// container, "chroot" runs them in Chroot inside it
+	Runner string `json:"runner"`
+	Chroot string `json:"chroot"`
+}
+
+// Struct for starting builds from fetches that found new work
+type ChainBuildConfig struct {
+	Enabled bool `json:"enabled"`
+	// How long to wait before triggering, fetches in the meantime join the
+	// same trigger
+	Debounce string `json:"debounce"`
+	// Shortest time between two build runs, a fetch inside it only extends a
+	// running build run
+	MinInterval string `json:"minInterval"`
+}
+
+// Schedules the builder keeps in Temporal
+const (
+	ScheduleFetch = "fetch"
+	ScheduleBuild = "build"
+)
+
+// Struct for a recurring workflow schedule
+type ScheduleConfig struct {
+	// Cron expressions, used instead of Interval when set
+	Cron []string `json:"cron"`
+	// Time between runs as a duration
+	Interval string `json:"interval"`
+	// Random delay added to every run
+	Jitter string `json:"jitter"`
+	// Keep the schedule paused, it can still be triggered by hand
+	Paused bool `json:"paused"`
+}
+
+// String describes the timing of the schedule.
+func (s ScheduleConfig) String() string {
+	if len(s.Cron) > 0 {
+		return "cron " + strings.Join(s.Cron, ", ")
+	}
+	return "every " + s.Interval
+}
+
+// DefaultSchedules are used for every schedule without timing configured.
+var DefaultSchedules = map[string]ScheduleConfig{
+	ScheduleFetch: {Interval: "1h", Jitter: "1m"},
+	ScheduleBuild: {Interval: "6h", Jitter: "1m"},
+}
+
+// Build profiles every builder provides
+const (
+	ProfileLTO      = "lto"
+	ProfileStandard = "standard"
+)
+
+// Struct for the builder of one architecture
+type BuilderConfig struct {
+	// Image the builder image is derived from
+	BaseImage string `json:"baseImage"`
+	// Repository the prepared builder images are committed to
+	Image           string `json:"image"`
+	ContainerPrefix string `json:"containerPrefix"`
+	// Command preparing the build environment in a fresh base image
+	InitCommand string `json:"initCommand"`
+	// Build command of every profile, run with the .dsc as argument
+	Profiles map[string]string `json:"profiles"`
+}
+
+// DefaultBuilder is the PikaOS amd64 builder, used for every field left
+// empty in the configuration.
+var DefaultBuilder = BuilderConfig{
+	BaseImage:       "ghcr.io/pikaos-linux/pika-base-debian-container:latest",
+	Image:           "pikaos-bldr-container",
+	ContainerPrefix: "pikaos-bldr-container",
+	InitCommand:     "pika-pbuilder-amd64-init",
+	Profiles: map[string]string{
+		ProfileLTO:      "pika-pbuilder-amd64-v3-lto-build",
+		ProfileStandard: "pika-pbuilder-amd64-v3-build",
+	},
+}
+
 // Struct for the overall configuration
 type Config struct {
 	SurrealHost          string        `json:"surrealHost"`
@@ -40,7 +151,108 @@ type Config struct {
 	ExternalPackageFiles []PackageFile `json:"externalPackageFiles"`
 	LTOBlocklist         []string      `json:"ltoBlocklist"`
 	DeboutputDir         string        `json:"deboutputDir"`
-	Salt                 string        `json:"salt"`
+	// Architecture this instance builds for, defaults to amd64
+	BuildArchitecture string `json:"buildArchitecture"`
+	// Builders by architecture
+	Builders map[string]BuilderConfig `json:"builders"`
+	// Number of builds running at the same time, defaults to 3
+	BuildConcurrency int `json:"buildConcurrency"`
+	// Wall clock limit of a build as a duration, empty means unlimited
+	BuildTimeout string `json:"buildTimeout"`
+	// Build time limits of single packages or sources, overriding BuildTimeout
+	BuildTimeouts map[string]string `json:"buildTimeouts"`
+	// Longest a build may go without output before it counts as hung
+	BuildInactivityTimeout string `json:"buildInactivityTimeout"`
+	// Wall clock limit of the install check, the autopkgtests and the builder
+	// image probe, defaults to 2h
+	CheckTimeout string `json:"checkTimeout"`
+	// Number of committed builder images kept for reverting
+	BuilderImageGenerations int               `json:"builderImageGenerations"`
+	InstallCheck            bool              `json:"installCheck"`
+	Autopkgtest             AutopkgtestConfig `json:"autopkgtest"`
+	// Key signing the build manifests, defaults to the repository key
+	ManifestSigningKey string `json:"manifestSigningKey"`
+	// Build every source twice and compare the outputs
+	ReproducibilityCheck bool `json:"reproducibilityCheck"`
+	// "temporal" runs the jobs as Temporal workflows, "builtin" in process
+	// without Temporal. Defaults to temporal
+	Scheduler string `json:"scheduler"`
+	// Schedules by name, see ScheduleFetch and ScheduleBuild
+	Schedules map[string]ScheduleConfig `json:"schedules"`
+	// Trigger builds when a fetch finds new work
+	ChainBuild ChainBuildConfig `json:"chainBuild"`
+	Repo       RepoConfig       `json:"repo"`
+	Salt       string           `json:"salt"`
+}
+
+// Architecture returns the architecture this instance builds for.
+func (c Config) Architecture() string {
+	if c.BuildArchitecture != "" {
+		return c.BuildArchitecture
+	}
+	return "amd64"
+}
+
+// CheckTimeoutValue returns the configured check time limit.
+func (c Config) CheckTimeoutValue() string {
+	if c.CheckTimeout != "" {
+		return c.CheckTimeout
+	}
+	return "2h"
+}
+
+// Concurrency returns how many builds may run at the same time.
+func (c Config) Concurrency() int {
+	if c.BuildConcurrency > 0 {
+		return c.BuildConcurrency
+	}
+	return 3
+}
+
+// Builder returns the builder of the build architecture, completed with
+// the defaults.
+func (c Config) Builder() BuilderConfig {
+	builder := c.Builders[c.Architecture()]
+	if builder.BaseImage == "" {
+		builder.BaseImage = DefaultBuilder.BaseImage
+	}
+	if builder.Image == "" {
+		builder.Image = DefaultBuilder.Image
+	}
+	if builder.ContainerPrefix == "" {
+		builder.ContainerPrefix = DefaultBuilder.ContainerPrefix
+	}
+	if builder.InitCommand == "" {
+		builder.InitCommand = DefaultBuilder.InitCommand
+	}
+	profiles := make(map[string]string, len(DefaultBuilder.Profiles))
+	for profile, command := range DefaultBuilder.Profiles {
+		profiles[profile] = command
+	}
+	for profile, command := range builder.Profiles {
+		profiles[profile] = command
+	}
+	builder.Profiles = profiles
+	return builder
+}
+
+// BuiltinScheduler reports whether jobs run in process instead of on
+// Temporal.
+func (c Config) BuiltinScheduler() bool {
+	return c.Scheduler == "builtin"
+}
+
+// Schedule returns the configuration of a schedule, its timing falls back to
+// the defaults when neither cron nor interval are set.
+func (c Config) Schedule(name string) ScheduleConfig {
+	schedule := c.Schedules[name]
+	if len(schedule.Cron) == 0 && schedule.Interval == "" {
+		schedule.Interval = DefaultSchedules[name].Interval
+		if schedule.Jitter == "" {
+			schedule.Jitter = DefaultSchedules[name].Jitter
+		}
+	}
+	return schedule
 }
 
 func Init() error {
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"errors"
 	"fmt"
 	"pkbldr/config"
 	"time"
@@ -8,6 +9,9 @@ import (
 	"github.com/surrealdb/surrealdb.go"
 )
 
+// ErrNotFound is returned by Record for records that don't exist.
+var ErrNotFound = errors.New("record not found")
+
 func New() (*surrealdb.DB, error) {
 	db, err := surrealdb.New(fmt.Sprintf("ws://%s:%d/rpc", config.Configs.SurrealHost, config.Configs.SurrealPort), surrealdb.WithTimeout(600*time.Second), surrealdb.UseWriteCompression(true))
 	if err != nil {
@@ -28,3 +32,23 @@ func New() (*surrealdb.DB, error) {
 
 	return db, nil
 }
+
+// Record decodes the result of selecting a single record. Depending on the
+// server a missing record comes back as no result or as an empty list, both
+// give ErrNotFound.
+func Record[T any](data interface{}, err error) (T, error) {
+	var record T
+	if errors.Is(err, surrealdb.ErrNoRow) || (err == nil && data == nil) {
+		return record, ErrNotFound
+	}
+	if err != nil {
+		return record, err
+	}
+	if list, ok := data.([]interface{}); ok {
+		if len(list) == 0 {
+			return record, ErrNotFound
+		}
+		data = list[0]
+	}
+	return surrealdb.SmartUnmarshal[T](data, nil)
+}
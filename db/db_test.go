@@ -0,0 +1,49 @@
+package db
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/surrealdb/surrealdb.go"
+)
+
+func TestRecord(t *testing.T) {
+	type record struct {
+		ID  string `json:"id"`
+		Tag string `json:"tag"`
+	}
+	failed := errors.New("connection lost")
+	tests := []struct {
+		name    string
+		data    interface{}
+		err     error
+		want    record
+		wantErr error
+	}{
+		{"no row", nil, surrealdb.ErrNoRow, record{}, ErrNotFound},
+		{"no result", nil, nil, record{}, ErrNotFound},
+		{"empty list", []interface{}{}, nil, record{}, ErrNotFound},
+		{"failure", nil, failed, record{}, failed},
+		{
+			name: "record",
+			data: map[string]interface{}{"id": "builderimage:pin", "tag": "gen-3"},
+			want: record{ID: "builderimage:pin", Tag: "gen-3"},
+		},
+		{
+			name: "record in a list",
+			data: []interface{}{map[string]interface{}{"id": "builderimage:pin", "tag": "gen-3"}},
+			want: record{ID: "builderimage:pin", Tag: "gen-3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Record[record](tt.data, tt.err)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Record() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Record() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
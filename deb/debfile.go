@@ -0,0 +1,180 @@
+package deb
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Errors while reading package files
+var (
+	ErrNotDeb          = errors.New("not a debian binary package")
+	ErrNoControlFile   = errors.New("control file not found in package")
+	ErrNoControlStanza = errors.New("control file is empty")
+)
+
+const (
+	arMagic      = "!<arch>\n"
+	arHeaderSize = 60
+)
+
+// GetControlFileFromDeb reads the control stanza of a .deb package.
+func GetControlFileFromDeb(packageFile string) (Stanza, error) {
+	file, err := os.Open(packageFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rdr := bufio.NewReader(file)
+	magic := make([]byte, len(arMagic))
+	if _, err = io.ReadFull(rdr, magic); err != nil || string(magic) != arMagic {
+		return nil, fmt.Errorf("%s: %w", packageFile, ErrNotDeb)
+	}
+
+	header := make([]byte, arHeaderSize)
+	for {
+		_, err = io.ReadFull(rdr, header)
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s: %w", packageFile, ErrNoControlFile)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name := strings.TrimSuffix(strings.TrimSpace(string(header[0:16])), "/")
+		size, err := strconv.ParseInt(strings.TrimSpace(string(header[48:58])), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", packageFile, ErrNotDeb)
+		}
+		member := io.LimitReader(rdr, size)
+
+		if strings.HasPrefix(name, "control.tar") {
+			stanza, err := readControlTar(member, path.Ext(name))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", packageFile, err)
+			}
+			return stanza, nil
+		}
+
+		// Members are aligned to an even offset
+		if size%2 == 1 {
+			size++
+		}
+		if _, err = rdr.Discard(int(size)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func readControlTar(rdr io.Reader, compression string) (Stanza, error) {
+	switch compression {
+	case ".gz":
+		r, err := gzip.NewReader(rdr)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		rdr = r
+	case ".xz":
+		r, err := xz.NewReader(rdr)
+		if err != nil {
+			return nil, err
+		}
+		rdr = r
+	case ".zst":
+		r, err := zstd.NewReader(rdr)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		rdr = r
+	case ".bz2":
+		rdr = bzip2.NewReader(rdr)
+	}
+
+	tr := tar.NewReader(rdr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, ErrNoControlFile
+		}
+		if err != nil {
+			return nil, err
+		}
+		if path.Clean(hdr.Name) != "control" {
+			continue
+		}
+
+		stanza, err := NewControlFileReader(tr, false, false).ReadStanza()
+		if err != nil {
+			return nil, err
+		}
+		if stanza == nil {
+			return nil, ErrNoControlStanza
+		}
+		return stanza, nil
+	}
+}
+
+// GetControlFileFromDsc reads the stanza of a .dsc source description,
+// dropping the OpenPGP armor of signed files.
+func GetControlFileFromDsc(dscFile string) (Stanza, error) {
+	data, err := os.ReadFile(dscFile)
+	if err != nil {
+		return nil, err
+	}
+
+	stanza, err := NewControlFileReader(bytes.NewReader(stripSignature(data)), false, false).ReadStanza()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", dscFile, err)
+	}
+	if stanza == nil {
+		return nil, fmt.Errorf("%s: %w", dscFile, ErrNoControlStanza)
+	}
+	return stanza, nil
+}
+
+func stripSignature(data []byte) []byte {
+	if !bytes.HasPrefix(data, []byte("-----BEGIN PGP SIGNED MESSAGE-----")) {
+		return data
+	}
+
+	// The armor headers end with the first empty line
+	start := bytes.Index(data, []byte("\n\n"))
+	if start == -1 {
+		return data
+	}
+	data = data[start+2:]
+	end := bytes.Index(data, []byte("\n-----BEGIN PGP SIGNATURE-----"))
+	if end != -1 {
+		data = data[:end+1]
+	}
+	return data
+}
+
+// SourceFiles returns the names of the files listed in the Files field of a
+// source stanza.
+func SourceFiles(stanza Stanza) []string {
+	files := make([]string, 0)
+	for _, line := range strings.Split(stanza["Files"], "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		files = append(files, fields[2])
+	}
+	return files
+}
@@ -0,0 +1,316 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"pkbldr/config"
+	"pkbldr/db"
+	"sync"
+	"time"
+
+	"github.com/surrealdb/surrealdb.go"
+)
+
+var dbInstance *surrealdb.DB
+var dbLock sync.Mutex
+
+type ActorKind string
+
+const (
+	// Scheduled fetch and build runs
+	Scheduler ActorKind = "scheduler"
+	// Logged in user of the web UI or API
+	User ActorKind = "user"
+	// Process running the builds
+	BuildWorker ActorKind = "buildworker"
+)
+
+// Actor is whoever caused an event.
+type Actor struct {
+	Kind ActorKind `json:"kind"`
+	Name string    `json:"name"`
+}
+
+func (a Actor) String() string {
+	if a.Name == "" {
+		return string(a.Kind)
+	}
+	return string(a.Kind) + ":" + a.Name
+}
+
+func SchedulerActor() Actor {
+	return Actor{Kind: Scheduler}
+}
+
+func UserActor(username string) Actor {
+	return Actor{Kind: User, Name: username}
+}
+
+// BuildWorkerActor identifies the build worker by the host it runs on.
+func BuildWorkerActor() Actor {
+	hostname, _ := os.Hostname()
+	return Actor{Kind: BuildWorker, Name: hostname}
+}
+
+type EventKind string
+
+const (
+	// Status of a package changed
+	StatusChange EventKind = "statuschange"
+	// Someone triggered an action by hand
+	ManualAction EventKind = "manualaction"
+	// The configuration changed between runs
+	ConfigChange EventKind = "configchange"
+	// A build moved between repository suites
+	Promotion EventKind = "promotion"
+)
+
+// Event is a single entry of the audit trail.
+type Event struct {
+	// Generated by the database
+	ID   string    `json:"id,omitempty"`
+	Time time.Time `json:"time"`
+	Kind EventKind `json:"kind"`
+	// Package the event applies to, empty for global events
+	Package string `json:"package"`
+	Actor   Actor  `json:"actor"`
+	// Field or action that changed
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+	Reason string `json:"reason"`
+}
+
+type configSnapshot struct {
+	ID     string                     `json:"id"`
+	Fields map[string]json.RawMessage `json:"fields"`
+}
+
+func connect() error {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+	if dbInstance != nil {
+		return nil
+	}
+	var err error
+	dbInstance, err = db.New()
+	return err
+}
+
+// Events are written in the background in batches, so recording one never
+// waits for the database
+const (
+	eventQueueSize = 1024
+	eventBatchSize = 100
+)
+
+var eventQueue = make(chan Event, eventQueueSize)
+var flushRequests = make(chan chan struct{})
+var writerOnce sync.Once
+
+// Writes a batch of events, replaced in tests
+var writeBatch = insertEvents
+
+// Record appends an event to the log. Failures are logged rather than
+// returned, losing an audit entry must never fail a build. The event is
+// dropped when the database falls too far behind.
+func Record(ev Event) {
+	// Created in the table so every event gets a record id of its own
+	ev.ID = ""
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	writerOnce.Do(func() { go writeEvents() })
+	select {
+	case eventQueue <- ev:
+	default:
+		slog.Error("event queue full, dropping event", "kind", ev.Kind, "package", ev.Package, "field", ev.Field)
+	}
+}
+
+// Flush waits until the events recorded so far are written, at most for
+// the timeout.
+func Flush(timeout time.Duration) {
+	writerOnce.Do(func() { go writeEvents() })
+	done := make(chan struct{})
+	select {
+	case flushRequests <- done:
+	case <-time.After(timeout):
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
+
+func writeEvents() {
+	for {
+		var batch []Event
+		var flushed chan struct{}
+		select {
+		case ev := <-eventQueue:
+			batch = append(batch, ev)
+		case flushed = <-flushRequests:
+		}
+		// Take whatever else is queued, everything on a flush
+	fill:
+		for flushed != nil || len(batch) < eventBatchSize {
+			select {
+			case ev := <-eventQueue:
+				batch = append(batch, ev)
+			default:
+				break fill
+			}
+		}
+		if len(batch) > 0 {
+			err := writeBatch(batch)
+			if err != nil {
+				slog.Error(fmt.Sprintf("unable to record %d events: %s", len(batch), err.Error()))
+			}
+		}
+		if flushed != nil {
+			close(flushed)
+		}
+	}
+}
+
+func insertEvents(batch []Event) error {
+	err := connect()
+	if err != nil {
+		return err
+	}
+	_, err = surrealdb.SmartUnmarshal[[]Event](dbInstance.Query(
+		"INSERT INTO packageevents $events",
+		map[string]interface{}{
+			"events": batch,
+		}))
+	return err
+}
+
+func RecordStatusChange(pkg string, actor Actor, before, after, reason string) {
+	Record(Event{
+		Kind:    StatusChange,
+		Package: pkg,
+		Actor:   actor,
+		Field:   "status",
+		Before:  before,
+		After:   after,
+		Reason:  reason,
+	})
+}
+
+// RecordManualAction logs an action a user took, pkg may be empty for
+// actions that aren't tied to a package.
+func RecordManualAction(pkg string, actor Actor, action, reason string) {
+	Record(Event{
+		Kind:    ManualAction,
+		Package: pkg,
+		Actor:   actor,
+		Field:   action,
+		Reason:  reason,
+	})
+}
+
+// ForPackage returns the newest events of a single package.
+func ForPackage(name string, limit int, offset int) ([]Event, error) {
+	err := connect()
+	if err != nil {
+		return nil, err
+	}
+	return surrealdb.SmartUnmarshal[[]Event](dbInstance.Query(
+		"SELECT * FROM packageevents WHERE package = $package ORDER BY time DESC LIMIT $limit START $offset",
+		map[string]interface{}{
+			"package": name,
+			"limit":   limit,
+			"offset":  offset,
+		}))
+}
+
+// Recent returns the newest events across all packages.
+func Recent(limit int, offset int) ([]Event, error) {
+	err := connect()
+	if err != nil {
+		return nil, err
+	}
+	return surrealdb.SmartUnmarshal[[]Event](dbInstance.Query(
+		"SELECT * FROM packageevents ORDER BY time DESC LIMIT $limit START $offset",
+		map[string]interface{}{
+			"limit":  limit,
+			"offset": offset,
+		}))
+}
+
+// TrackConfig compares the loaded configuration with the one seen on the
+// previous start and records every top level field that changed.
+func TrackConfig(actor Actor) error {
+	err := connect()
+	if err != nil {
+		return err
+	}
+
+	current, err := configFields(config.Configs)
+	if err != nil {
+		return err
+	}
+
+	previous, err := db.Record[configSnapshot](dbInstance.Select("configsnapshot:`current`"))
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		return err
+	}
+
+	// Nothing to compare against on the very first start
+	if previous.Fields != nil {
+		for field, after := range current {
+			before := previous.Fields[field]
+			if string(before) == string(after) {
+				continue
+			}
+			Record(Event{
+				Kind:   ConfigChange,
+				Actor:  actor,
+				Field:  field,
+				Before: string(before),
+				After:  string(after),
+				Reason: "configuration reloaded",
+			})
+		}
+		for field, before := range previous.Fields {
+			if _, ok := current[field]; !ok {
+				Record(Event{
+					Kind:   ConfigChange,
+					Actor:  actor,
+					Field:  field,
+					Before: string(before),
+					Reason: "configuration reloaded",
+				})
+			}
+		}
+	}
+
+	_, err = surrealdb.SmartMarshal(dbInstance.Update, configSnapshot{
+		ID:     "configsnapshot:`current`",
+		Fields: current,
+	})
+	return err
+}
+
+// configFields splits the configuration into its top level fields, leaving
+// out the secrets.
+func configFields(cfg config.Config) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, fmt.Errorf("unable to split configuration: %w", err)
+	}
+	delete(fields, "surrealPassword")
+	delete(fields, "salt")
+	return fields, nil
+}
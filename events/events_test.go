@@ -0,0 +1,47 @@
+package events
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRecordDoesNotWaitForTheDatabase(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var written []Event
+	defer func(write func([]Event) error) { writeBatch = write }(writeBatch)
+	writeBatch = func(batch []Event) error {
+		<-release
+		mu.Lock()
+		defer mu.Unlock()
+		written = append(written, batch...)
+		return nil
+	}
+
+	recorded := make(chan struct{})
+	go func() {
+		for i := 0; i < 2*eventQueueSize; i++ {
+			RecordStatusChange("hello", SchedulerActor(), "Stale", "Queued", "")
+		}
+		close(recorded)
+	}()
+	select {
+	case <-recorded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Record blocked on a stalled database")
+	}
+
+	close(release)
+	Flush(5 * time.Second)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(written) == 0 || len(written) > 2*eventQueueSize {
+		t.Fatalf("wrote %d events", len(written))
+	}
+	for _, ev := range written {
+		if ev.ID != "" || ev.Time.IsZero() || ev.Kind != StatusChange {
+			t.Fatalf("unexpected event %+v", ev)
+		}
+	}
+}
@@ -1,14 +1,28 @@
 package main
 
 import (
+	"errors"
+	"pkbldr/builds"
+	"pkbldr/config"
+	"pkbldr/events"
+	"pkbldr/images"
 	"pkbldr/packages"
+	"pkbldr/repo"
+	"pkbldr/starters"
 	"pkbldr/templates"
 	"pkbldr/templates/pages"
+	pages_builds "pkbldr/templates/pages/builds"
+	pages_events "pkbldr/templates/pages/events"
+	pages_images "pkbldr/templates/pages/images"
 	pages_packages "pkbldr/templates/pages/packages"
+	pages_promotions "pkbldr/templates/pages/promotions"
+	pages_runs "pkbldr/templates/pages/runs"
+	pages_schedules "pkbldr/templates/pages/schedules"
 	"strconv"
 	"strings"
 
 	"github.com/a-h/templ"
+	"github.com/docker/docker/client"
 	"github.com/gofiber/fiber/v2/middleware/adaptor"
 
 	"github.com/gofiber/fiber/v2"
@@ -64,15 +78,21 @@ func packagesPageHandler(c *fiber.Ctx) error {
 		pageInt = 1
 	}
 
-	allPackages := packages.GetPackagesSlice()
-
 	// Apply filters
 	var filteredPackages []packages.PackageInfo
-	for _, pkg := range allPackages {
-		if (statusFilter == "all" || statusFilter == "" || strings.ToLower(string(pkg.LastBuildStatus)) == statusFilter || strings.ToLower(string(pkg.Status)) == statusFilter) &&
-			(nameFilter == "" || strings.Contains(pkg.Name, nameFilter)) {
-			filteredPackages = append(filteredPackages, pkg)
+	if statusFilter == "all" || statusFilter == "" {
+		filteredPackages = packages.GetPackagesSlice()
+	} else if status, ok := packages.ParsePackageStatus(statusFilter); ok {
+		filteredPackages = packages.GetPackagesByStatus(status)
+	}
+	if nameFilter != "" {
+		var matchedPackages []packages.PackageInfo
+		for _, pkg := range filteredPackages {
+			if strings.Contains(pkg.Name, nameFilter) {
+				matchedPackages = append(matchedPackages, pkg)
+			}
 		}
+		filteredPackages = matchedPackages
 	}
 
 	// Pagination
@@ -102,3 +122,249 @@ func packagesPageHandler(c *fiber.Ctx) error {
 
 	return adaptor.HTTPHandler(templateHandler)(c)
 }
+
+func eventsPageHandler(c *fiber.Ctx) error {
+	metaTags := pages.MetaTags(
+		"PikaOS, packages, package builder, build system", // define meta keywords
+		"Welcome to the PikaOS Package Builder",           // define meta description
+	)
+
+	page := c.Query("page", "1")
+	packageFilter := c.Query("package", "")
+
+	pageInt, err := strconv.Atoi(page)
+	if err != nil || pageInt < 1 {
+		pageInt = 1
+	}
+
+	// Fetch one more than a page to know whether there is a next one
+	var eventList []events.Event
+	if packageFilter != "" {
+		eventList, err = events.ForPackage(packageFilter, pageSize+1, (pageInt-1)*pageSize)
+	} else {
+		eventList, err = events.Recent(pageSize+1, (pageInt-1)*pageSize)
+	}
+	if err != nil {
+		return err
+	}
+	hasNext := len(eventList) > pageSize
+	if hasNext {
+		eventList = eventList[:pageSize]
+	}
+	nextPage := "/events?page=" + strconv.Itoa(pageInt+1) + "&package=" + packageFilter
+	prevPage := "/events?page=" + strconv.Itoa(pageInt-1) + "&package=" + packageFilter
+
+	bodyContent := pages_events.BodyContent(eventList, pageInt, hasNext, nextPage, prevPage, packageFilter)
+
+	templateHandler := templ.Handler(
+		templates.Layout(
+			"PikaOS Package Builder - Events", // define title text
+			metaTags, bodyContent, false,
+		),
+	)
+
+	return adaptor.HTTPHandler(templateHandler)(c)
+}
+
+func buildsPageHandler(c *fiber.Ctx) error {
+	metaTags := pages.MetaTags(
+		"PikaOS, packages, package builder, build system", // define meta keywords
+		"Welcome to the PikaOS Package Builder",           // define meta description
+	)
+
+	page := c.Query("page", "1")
+	sourceFilter := c.Query("source", "")
+
+	pageInt, err := strconv.Atoi(page)
+	if err != nil || pageInt < 1 {
+		pageInt = 1
+	}
+
+	// Fetch one more than a page to know whether there is a next one
+	var buildList []builds.Build
+	if sourceFilter != "" {
+		buildList, err = builds.ForSource(sourceFilter, pageSize+1, (pageInt-1)*pageSize)
+	} else {
+		buildList, err = builds.Recent(pageSize+1, (pageInt-1)*pageSize)
+	}
+	if err != nil {
+		return err
+	}
+	hasNext := len(buildList) > pageSize
+	if hasNext {
+		buildList = buildList[:pageSize]
+	}
+	nextPage := "/builds?page=" + strconv.Itoa(pageInt+1) + "&source=" + sourceFilter
+	prevPage := "/builds?page=" + strconv.Itoa(pageInt-1) + "&source=" + sourceFilter
+
+	bodyContent := pages_builds.BodyContent(buildList, pageInt, hasNext, nextPage, prevPage, sourceFilter)
+
+	templateHandler := templ.Handler(
+		templates.Layout(
+			"PikaOS Package Builder - Builds", // define title text
+			metaTags, bodyContent, false,
+		),
+	)
+
+	return adaptor.HTTPHandler(templateHandler)(c)
+}
+
+func promotionsPageHandler(c *fiber.Ctx) error {
+	metaTags := pages.MetaTags(
+		"PikaOS, packages, package builder, build system", // define meta keywords
+		"Welcome to the PikaOS Package Builder",           // define meta description
+	)
+
+	if !repo.StagingEnabled() {
+		return fiber.NewError(fiber.StatusNotFound, "staging is not enabled")
+	}
+	staged, err := repo.ListPromotions(repo.Staged)
+	if err != nil {
+		return err
+	}
+	promoted, err := repo.ListPromotions(repo.Promoted)
+	if err != nil {
+		return err
+	}
+
+	bodyContent := pages_promotions.BodyContent(staged, promoted, repo.StagingSuite(), config.Configs.Repo.Suite)
+
+	templateHandler := templ.Handler(
+		templates.Layout(
+			"PikaOS Package Builder - Promotions", // define title text
+			metaTags, bodyContent, false,
+		),
+	)
+
+	return adaptor.HTTPHandler(templateHandler)(c)
+}
+
+func promoteHandler(c *fiber.Ctx) error {
+	err := promote(c, c.FormValue("source"), c.FormValue("version"), "promoted from the web UI")
+	if err != nil {
+		return err
+	}
+	return c.Redirect("/promotions")
+}
+
+func imagesPageHandler(c *fiber.Ctx) error {
+	metaTags := pages.MetaTags(
+		"PikaOS, packages, package builder, build system", // define meta keywords
+		"Welcome to the PikaOS Package Builder",           // define meta description
+	)
+
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+	generations, err := images.List(c.Context(), cli)
+	if err != nil {
+		return err
+	}
+	pinned, err := images.Pinned()
+	if err != nil {
+		return err
+	}
+
+	bodyContent := pages_images.BodyContent(generations, pinned)
+
+	templateHandler := templ.Handler(
+		templates.Layout(
+			"PikaOS Package Builder - Builder Images", // define title text
+			metaTags, bodyContent, false,
+		),
+	)
+
+	return adaptor.HTTPHandler(templateHandler)(c)
+}
+
+func revertImageHandler(c *fiber.Ctx) error {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+	err = images.Revert(c.Context(), cli, c.FormValue("tag"), currentActor(c))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	return c.Redirect("/images")
+}
+
+func unpinImageHandler(c *fiber.Ctx) error {
+	err := images.Unpin(currentActor(c))
+	if err != nil {
+		return err
+	}
+	return c.Redirect("/images")
+}
+
+func runPageHandler(c *fiber.Ctx) error {
+	metaTags := pages.MetaTags(
+		"PikaOS, packages, package builder, build system", // define meta keywords
+		"Welcome to the PikaOS Package Builder",           // define meta description
+	)
+
+	// The builtin scheduler runs builds without a controllable build run
+	var run starters.BuildRun
+	running := false
+	if temporalClient != nil {
+		var err error
+		run, err = starters.GetBuildRun(c.Context(), temporalClient)
+		running = err == nil
+		if err != nil && !errors.Is(err, starters.ErrNoBuildRun) {
+			return err
+		}
+	}
+
+	bodyContent := pages_runs.BodyContent(run, running)
+
+	templateHandler := templ.Handler(
+		templates.Layout(
+			"PikaOS Package Builder - Build Run", // define title text
+			metaTags, bodyContent, false,
+		),
+	)
+
+	return adaptor.HTTPHandler(templateHandler)(c)
+}
+
+func runSignalHandler(c *fiber.Ctx) error {
+	err := signalBuildRun(c)
+	if err != nil {
+		return err
+	}
+	return c.Redirect("/run")
+}
+
+func schedulesPageHandler(c *fiber.Ctx) error {
+	metaTags := pages.MetaTags(
+		"PikaOS, packages, package builder, build system", // define meta keywords
+		"Welcome to the PikaOS Package Builder",           // define meta description
+	)
+
+	schedules, err := listSchedules(c)
+	if err != nil {
+		return err
+	}
+
+	bodyContent := pages_schedules.BodyContent(schedules)
+
+	templateHandler := templ.Handler(
+		templates.Layout(
+			"PikaOS Package Builder - Schedules", // define title text
+			metaTags, bodyContent, false,
+		),
+	)
+
+	return adaptor.HTTPHandler(templateHandler)(c)
+}
+
+func scheduleActionHandler(c *fiber.Ctx) error {
+	err := runScheduleAction(c)
+	if err != nil {
+		return err
+	}
+	return c.Redirect("/schedules")
+}
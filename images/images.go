@@ -0,0 +1,267 @@
+package images
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"pkbldr/config"
+	"pkbldr/db"
+	"pkbldr/events"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/surrealdb/surrealdb.go"
+)
+
+// Repository returns the repository the builder images are committed to.
+func Repository() string {
+	return config.Configs.Builder().Image
+}
+
+// Latest returns the builder image the builds run in.
+func Latest() string {
+	return Repository() + ":latest"
+}
+
+// Label recording the digest of the base image a builder image derives from
+const BaseDigestLabel = "pkbldr.base-digest"
+
+// Number of builder images kept when none is configured
+const defaultGenerations = 5
+
+// Layout of the generation tags, sorts chronologically
+const tagLayout = "20060102-150405"
+
+var dbInstance *surrealdb.DB
+var dbLock sync.Mutex
+
+var ErrUnknownGeneration = errors.New("unknown builder image generation")
+
+// Generation is a committed builder image.
+type Generation struct {
+	Tag     string    `json:"tag"`
+	ID      string    `json:"id"`
+	Created time.Time `json:"created"`
+	// Latest points at this generation
+	Current bool `json:"current"`
+}
+
+type pin struct {
+	ID  string `json:"id"`
+	Tag string `json:"tag"`
+}
+
+func connect() error {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+	if dbInstance != nil {
+		return nil
+	}
+	var err error
+	dbInstance, err = db.New()
+	return err
+}
+
+func generations() int {
+	if config.Configs.BuilderImageGenerations > 0 {
+		return config.Configs.BuilderImageGenerations
+	}
+	return defaultGenerations
+}
+
+// Commit commits a prepared builder container as a new generation, points
+// Latest at it and prunes the generations beyond the retention limit.
+// baseDigest is the digest of the base image the container was created from.
+func Commit(ctx context.Context, cli *client.Client, containerID string, baseDigest string) (string, error) {
+	tag := time.Now().UTC().Format(tagLayout)
+	options := types.ContainerCommitOptions{Reference: Repository() + ":" + tag}
+	if baseDigest != "" {
+		options.Changes = []string{"LABEL " + BaseDigestLabel + "=" + baseDigest}
+	}
+	_, err := cli.ContainerCommit(ctx, containerID, options)
+	if err != nil {
+		return "", err
+	}
+	err = cli.ImageTag(ctx, Repository()+":"+tag, Latest())
+	if err != nil {
+		return "", err
+	}
+
+	err = prune(ctx, cli)
+	if err != nil {
+		slog.Error("unable to prune builder images: " + err.Error())
+	}
+	return tag, nil
+}
+
+// List returns the builder image generations, newest first.
+func List(ctx context.Context, cli *client.Client) ([]Generation, error) {
+	summaries, err := cli.ImageList(ctx, types.ImageListOptions{
+		Filters: filters.NewArgs(filters.Arg("reference", Repository())),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	current := ""
+	for _, summary := range summaries {
+		for _, repoTag := range summary.RepoTags {
+			if repoTag == Latest() {
+				current = summary.ID
+			}
+		}
+	}
+
+	list := make([]Generation, 0)
+	for _, summary := range summaries {
+		for _, repoTag := range summary.RepoTags {
+			tag, found := strings.CutPrefix(repoTag, Repository()+":")
+			if !found || tag == "latest" {
+				continue
+			}
+			list = append(list, Generation{
+				Tag:     tag,
+				ID:      summary.ID,
+				Created: time.Unix(summary.Created, 0).UTC(),
+				Current: summary.ID == current,
+			})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Tag > list[j].Tag
+	})
+	return list, nil
+}
+
+// TagOf returns the generation tag of an image ID, or an empty string.
+func TagOf(ctx context.Context, cli *client.Client, imageID string) string {
+	inspect, _, err := cli.ImageInspectWithRaw(ctx, imageID)
+	if err != nil {
+		return ""
+	}
+	for _, repoTag := range inspect.RepoTags {
+		tag, found := strings.CutPrefix(repoTag, Repository()+":")
+		if found && tag != "latest" {
+			return tag
+		}
+	}
+	return ""
+}
+
+func prune(ctx context.Context, cli *client.Client) error {
+	list, err := List(ctx, cli)
+	if err != nil {
+		return err
+	}
+	pinned, _ := Pinned()
+	for i, generation := range list {
+		if i < generations() || generation.Current || generation.Tag == pinned {
+			continue
+		}
+		_, err = cli.ImageRemove(ctx, Repository()+":"+generation.Tag, types.ImageRemoveOptions{PruneChildren: true})
+		if err != nil {
+			slog.Error("unable to remove builder image " + generation.Tag + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// Revert points Latest back at an older generation and pins it, so the next
+// image update doesn't replace it right away.
+func Revert(ctx context.Context, cli *client.Client, tag string, actor events.Actor) error {
+	list, err := List(ctx, cli)
+	if err != nil {
+		return err
+	}
+	before := ""
+	found := false
+	for _, generation := range list {
+		if generation.Current {
+			before = generation.Tag
+		}
+		if generation.Tag == tag {
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("%s: %w", tag, ErrUnknownGeneration)
+	}
+
+	err = cli.ImageTag(ctx, Repository()+":"+tag, Latest())
+	if err != nil {
+		return err
+	}
+	err = setPin(tag)
+	if err != nil {
+		return err
+	}
+	events.Record(events.Event{
+		Kind:   events.ManualAction,
+		Actor:  actor,
+		Field:  "builderimage",
+		Before: before,
+		After:  tag,
+		Reason: "reverted builder image",
+	})
+	return nil
+}
+
+// Unpin lets the next image update commit a new generation again.
+func Unpin(actor events.Actor) error {
+	tag, err := Pinned()
+	if err != nil || tag == "" {
+		return err
+	}
+	err = setPin("")
+	if err != nil {
+		return err
+	}
+	events.Record(events.Event{
+		Kind:   events.ManualAction,
+		Actor:  actor,
+		Field:  "builderimage",
+		Before: tag,
+		Reason: "unpinned builder image",
+	})
+	return nil
+}
+
+// Pinned returns the tag of the pinned generation, empty when none is.
+func Pinned() (string, error) {
+	err := connect()
+	if err != nil {
+		return "", err
+	}
+	return pinnedTag(dbInstance.Select("builderimage:`pin`"))
+}
+
+// pinnedTag decodes the pin record, which doesn't exist until an image was
+// first pinned.
+func pinnedTag(data interface{}, err error) (string, error) {
+	p, err := db.Record[pin](data, err)
+	if errors.Is(err, db.ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return p.Tag, nil
+}
+
+func setPin(tag string) error {
+	err := connect()
+	if err != nil {
+		return err
+	}
+	_, err = surrealdb.SmartMarshal(dbInstance.Update, pin{
+		ID:  "builderimage:`pin`",
+		Tag: tag,
+	})
+	return err
+}
@@ -0,0 +1,33 @@
+package images
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/surrealdb/surrealdb.go"
+)
+
+func TestPinnedTag(t *testing.T) {
+	failed := errors.New("connection lost")
+	tests := []struct {
+		name    string
+		data    interface{}
+		err     error
+		want    string
+		wantErr error
+	}{
+		{"never pinned", nil, surrealdb.ErrNoRow, "", nil},
+		{"never pinned, empty result", []interface{}{}, nil, "", nil},
+		{"unpinned", map[string]interface{}{"id": "builderimage:pin", "tag": ""}, nil, "", nil},
+		{"pinned", map[string]interface{}{"id": "builderimage:pin", "tag": "gen-2"}, nil, "gen-2", nil},
+		{"lookup failed", nil, failed, "", failed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := pinnedTag(tt.data, tt.err)
+			if !errors.Is(err, tt.wantErr) || got != tt.want {
+				t.Errorf("pinnedTag() = %q, %v, want %q, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
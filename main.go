@@ -2,36 +2,33 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"log/slog"
 	"os"
 	"os/signal"
+	"pkbldr/events"
+	"syscall"
+	"time"
 )
 
-func main() {
-	// Run your server.
-	ctx := context.Background()
+// Longest time spent writing queued events on exit
+const eventsFlushTimeout = 5 * time.Second
 
-	// trap Ctrl+C and call cancel on the context
-	ctx, cancel := context.WithCancel(ctx)
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	defer func() {
-		signal.Stop(c)
-		cancel()
-	}()
-	go func() {
-		select {
-		case <-c:
-			cancel()
-		case <-ctx.Done():
-		}
-	}()
+func main() {
+	// Cancel the context on Ctrl+C and SIGTERM, the commands shut down
+	// gracefully and return
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 
-	go func() {
-		if err := runServer(ctx); err != nil {
-			slog.Error("Failed to start server!", "details", err.Error())
-			os.Exit(1)
-		}
-	}()
-	<-ctx.Done()
+	err := runCommand(ctx, os.Args[1:])
+	stop()
+	// Write the audit events still queued
+	events.Flush(eventsFlushTimeout)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		slog.Error("Failed to run command!", "details", err.Error())
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"pkbldr/config"
+	"pkbldr/packages"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var packagesDesc = prometheus.NewDesc("pkbldr_packages", "Packages by current status.", []string{"status"}, nil)
+
+// packagesCollector reads the package counts from the store on every scrape.
+type packagesCollector struct{}
+
+func (packagesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- packagesDesc
+}
+
+func (packagesCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, status := range packages.Statuses {
+		ch <- prometheus.MustNewConstMetric(packagesDesc, prometheus.GaugeValue, float64(packages.CountStatus(status)), string(status))
+	}
+}
+
+var registerOnce sync.Once
+
+// registerMetrics registers the metrics read from the package store and the
+// configuration, the others register themselves in pkbldr/metrics.
+func registerMetrics() {
+	registerOnce.Do(func() {
+		prometheus.MustRegister(
+			packagesCollector{},
+			prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Name: "pkbldr_build_queue_sources",
+				Help: "Sources waiting in the build queue.",
+			}, func() float64 {
+				return float64(len(packages.GetBuildQueue()))
+			}),
+			prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Name: "pkbldr_builders_capacity",
+				Help: "Builds a build worker runs at the same time.",
+			}, func() float64 {
+				return float64(config.Configs.Concurrency())
+			}),
+		)
+	})
+}
+
+func metricsHandler(c *fiber.Ctx) error {
+	return adaptor.HTTPHandler(promhttp.Handler())(c)
+}
+
+// serveMetrics serves /metrics on addr until ctx is cancelled, for processes
+// without the web server.
+func serveMetrics(ctx context.Context, addr string) {
+	registerMetrics()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+	err := server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("unable to serve metrics: " + err.Error())
+	}
+}
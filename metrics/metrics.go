@@ -0,0 +1,61 @@
+// Package metrics holds the Prometheus metrics the builder records. The
+// gauges read from the package store are collected by the server.
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "pkbldr"
+
+// Build outcomes counted by BuildsTotal
+const (
+	OutcomeSucceeded = "succeeded"
+	OutcomeFailed    = "failed"
+	// Succeeded by downloading the upstream packages
+	OutcomeFallback = "fallback"
+)
+
+var (
+	BuildDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "build_duration_seconds",
+		Help:      "Duration of source package builds by strategy.",
+		// 30s to about 4h
+		Buckets: prometheus.ExponentialBuckets(30, 2, 10),
+	}, []string{"strategy"})
+
+	BuildsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "builds_total",
+		Help:      "Finished source package builds by outcome.",
+	}, []string{"outcome"})
+
+	BuildersBusy = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "builders_busy",
+		Help:      "Builds running in this process.",
+	})
+
+	FetchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "fetch_duration_seconds",
+		Help:      "Duration of package index fetches by package file and subrepo.",
+		Buckets:   prometheus.ExponentialBuckets(0.25, 2, 10),
+	}, []string{"package_file", "subrepo"})
+
+	DBWriteDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_write_duration_seconds",
+		Help:      "Duration of database writes by record kind.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"record"})
+)
+
+// ObserveSince records the time elapsed since start.
+func ObserveSince(o prometheus.Observer, start time.Time) {
+	o.Observe(time.Since(start).Seconds())
+}
@@ -2,6 +2,8 @@ package packages
 
 import (
 	"compress/bzip2"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -9,7 +11,10 @@ import (
 	"pkbldr/config"
 	"pkbldr/db"
 	"pkbldr/deb"
+	"pkbldr/events"
+	"pkbldr/metrics"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -20,132 +25,203 @@ import (
 	"github.com/ulikunitz/xz"
 )
 
-var packagesSlice []PackageInfo = make([]PackageInfo, 0)
-var updatedPackagesSlice []PackageInfo = make([]PackageInfo, 0)
 var LastUpdateTime time.Time
 
+var ErrUnknownPackage = errors.New("unknown package")
+
 var dbInstance *surrealdb.DB
 
 func GetPackagesSlice() []PackageInfo {
-	return packagesSlice
+	return store.all()
+}
+
+func GetPackage(name string) (PackageInfo, bool) {
+	return store.get(name)
+}
+
+func GetPackagesBySource(source string) []PackageInfo {
+	return store.withSource(source)
+}
+
+// GetPackagesByStatus returns the packages whose current or last build status
+// matches the given status.
+func GetPackagesByStatus(status PackageStatus) []PackageInfo {
+	return store.withStatus(status)
+}
+
+// FetchSummary lists the packages a fetch newly marked as needing a build.
+type FetchSummary struct {
+	Stale   []string `json:"stale"`
+	Missing []string `json:"missing"`
 }
 
-func ProcessPackages() error {
+func (s FetchSummary) Empty() bool {
+	return len(s.Stale) == 0 && len(s.Missing) == 0
+}
+
+func (s *FetchSummary) add(pkg PackageInfo) {
+	switch pkg.Status {
+	case Stale:
+		s.Stale = append(s.Stale, pkg.Name)
+	case Missing:
+		s.Missing = append(s.Missing, pkg.Name)
+	}
+}
+
+func ProcessPackages() (FetchSummary, error) {
+	var summary FetchSummary
 	var internalPackages = make(map[string]PackageInfo)
 	var externalPackages = make(map[string]PackageInfo)
 	err := LoadInternalPackages(internalPackages)
 	if err != nil {
-		return err
+		return summary, err
 	}
 	err = LoadExternalPackages(externalPackages)
 	if err != nil {
-		return err
+		return summary, err
 	}
 	ProcessStalePackages(internalPackages, externalPackages)
 	ProcessMissingPackages(internalPackages, externalPackages)
-	newPackagesSlice := make([]PackageInfo, 0)
-	for _, v := range internalPackages {
-		newPackagesSlice = append(newPackagesSlice, v)
-	}
-	slices.SortStableFunc(newPackagesSlice, func(a, b PackageInfo) int {
-		if a.Name == b.Name {
-			return 0
+	updatedPackages := make([]PackageInfo, 0)
+	for _, pkg2 := range internalPackages {
+		pkg, found := store.get(pkg2.Name)
+		if !found {
+			events.RecordStatusChange(pkg2.Name, events.SchedulerActor(), "", string(pkg2.Status), "new package in indexes")
+			pkg2.LastBuildStatus = ""
+			pkg2.StatusChangedAt = time.Now()
+			updatedPackages = append(updatedPackages, pkg2)
+			summary.add(pkg2)
+			continue
 		}
-		if a.Name > b.Name {
-			return 1
+		if IsInProgress(pkg.Status) {
+			// The running build settles the status
+			continue
 		}
-		return -1
-	})
-
-	for _, pkg2 := range newPackagesSlice {
-		found := false
-		for _, pkg := range packagesSlice {
-			if pkg2.Name == pkg.Name {
-				found = true
-				if pkg.Status == Stale && pkg2.Status != Stale {
-					pkg.Status = pkg2.Status
-					pkg.Version = pkg2.Version
-					pkg.PendingVersion = ""
-					updatedPackagesSlice = append(updatedPackagesSlice, pkg)
-				}
-				if pkg.Status == Missing && pkg2.Status != Missing {
-					pkg.PendingVersion = pkg2.PendingVersion
-					pkg.Version = pkg2.Version
-					pkg.Status = pkg2.Status
-					updatedPackagesSlice = append(updatedPackagesSlice, pkg)
-				}
-				if pkg.Status == Missing && pkg2.Status == Missing {
-					pkg.PendingVersion = pkg2.PendingVersion
-					pkg.Version = pkg2.Version
-					updatedPackagesSlice = append(updatedPackagesSlice, pkg)
-				}
-				if pkg.Status == Stale && pkg2.Status == Missing {
-					pkg.PendingVersion = pkg2.PendingVersion
-					pkg.Version = pkg2.Version
-					pkg.Status = pkg2.Status
-					updatedPackagesSlice = append(updatedPackagesSlice, pkg)
-				}
-				if (pkg2.Status == Stale || pkg2.Status == Missing) && (pkg.Status == Uptodate || pkg.Status == Stale || pkg.Status == Built || pkg.Status == Error) {
-					pkg.PendingVersion = pkg2.PendingVersion
-					pkg.Status = pkg2.Status
-					updatedPackagesSlice = append(updatedPackagesSlice, pkg)
-				}
+		if pkg.RebuildRequested && pkg2.Status == Uptodate {
+			// The index has nothing newer but the rebuild is still owed
+			continue
+		}
+		if pkg.Status != pkg2.Status {
+			reason := "package indexes report version " + pkg2.Version
+			if pkg2.PendingVersion != "" {
+				reason = "package indexes report version " + pkg2.PendingVersion
 			}
+			err := Transition(&pkg, EventIndexChanged, pkg2.Status, events.SchedulerActor(), reason)
+			if err != nil {
+				continue
+			}
+			summary.add(pkg)
+		} else if pkg.Version == pkg2.Version && pkg.PendingVersion == pkg2.PendingVersion {
+			continue
 		}
-		if !found {
-			pkg2.LastBuildStatus = ""
-			updatedPackagesSlice = append(updatedPackagesSlice, pkg2)
+		switch pkg2.Status {
+		case Uptodate:
+			pkg.Version = pkg2.Version
+			pkg.PendingVersion = ""
+		case Stale:
+			pkg.PendingVersion = pkg2.PendingVersion
+		case Missing:
+			pkg.Version = pkg2.Version
+			pkg.PendingVersion = pkg2.PendingVersion
 		}
+		updatedPackages = append(updatedPackages, pkg)
 	}
 	LastUpdateTime = time.Now()
-	err = SaveToDb()
+	err = SaveToDb(updatedPackages)
 	if err != nil {
-		return err
+		return summary, err
 	}
 	err = LoadFromDb()
 	if err != nil {
-		return err
+		return summary, err
 	}
-	return nil
+	return summary, nil
 }
 
 type PackageBuildQueue map[string][]PackageInfo
 
 func GetBuildQueue() PackageBuildQueue {
-	buildQueue := make(map[string][]PackageInfo)
-	for _, pkg := range packagesSlice {
-		if !(pkg.Status == Missing || pkg.Status == Stale) {
-			continue
-		}
+	return withoutHeld(store.sourcesWithStatus(Missing, Stale))
+}
 
-		key := pkg.Source
-		if key == "" {
-			key = pkg.Name
-		}
-		existing, ok := buildQueue[key]
-		if !ok {
-			existing = make([]PackageInfo, 0)
+// withoutHeld drops the sources with a held package from a build queue.
+func withoutHeld(queue PackageBuildQueue) PackageBuildQueue {
+	for source := range queue {
+		for _, pkg := range store.withSource(source) {
+			if pkg.Held {
+				delete(queue, source)
+				break
+			}
 		}
-		existing = append(existing, pkg)
-		buildQueue[key] = existing
 	}
-	return buildQueue
+	return queue
 }
 
-func UpdatePackage(pkg PackageInfo, updateDB bool) error {
-	for i, v := range packagesSlice {
-		if pkg.Name == v.Name {
-			packagesSlice[i] = pkg
-			if updateDB {
-				err := saveSingleToDb(pkg)
-				if err != nil {
-					return err
-				}
+// SetHold holds a package or releases it again.
+func SetHold(name string, held bool, actor events.Actor, reason string) error {
+	pkg, ok := store.get(name)
+	if !ok {
+		return fmt.Errorf("%s: %w", name, ErrUnknownPackage)
+	}
+	if pkg.Held == held {
+		return nil
+	}
+	pkg.Held = held
+	pkg.HoldReason = ""
+	if held {
+		pkg.HoldReason = reason
+	}
+	err := mergePackage(name, map[string]interface{}{
+		"held":       pkg.Held,
+		"holdreason": pkg.HoldReason,
+	})
+	if err != nil {
+		return err
+	}
+	err = UpdatePackage(pkg, false)
+	if err != nil {
+		return err
+	}
+	events.Record(events.Event{
+		Kind:    events.ManualAction,
+		Package: name,
+		Actor:   actor,
+		Field:   "held",
+		Before:  strconv.FormatBool(!held),
+		After:   strconv.FormatBool(held),
+		Reason:  reason,
+	})
+	return nil
+}
+
+// RequestRebuild marks every package built from the source of a package for
+// another build. Packages already queued or building are left alone.
+func RequestRebuild(name string, actor events.Actor) error {
+	requested, ok := store.get(name)
+	if !ok {
+		return fmt.Errorf("%s: %w", name, ErrUnknownPackage)
+	}
+	for _, pkg := range store.withSource(sourceKey(requested)) {
+		if IsInProgress(pkg.Status) {
+			continue
+		}
+		if pkg.Status != Stale && pkg.Status != Missing {
+			err := Transition(&pkg, EventRebuildRequested, Stale, actor, "rebuild requested")
+			if err != nil {
+				return err
 			}
-			LastUpdateTime = time.Now()
-			break
+		}
+		pkg.RebuildRequested = true
+		err := UpdatePackage(pkg, true)
+		if err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+func UpdatePackage(pkg PackageInfo, updateDB bool) error {
+	store.put(pkg)
 	if updateDB {
 		err := saveSingleToDb(pkg)
 		if err != nil {
@@ -157,18 +233,39 @@ func UpdatePackage(pkg PackageInfo, updateDB bool) error {
 }
 
 func IsBuilt(pkg PackageInfo) bool {
-	for _, v := range packagesSlice {
-		if pkg.Name == v.Name {
-			if v.Status == Built {
-				return true
-			}
-			break
-		}
+	v, ok := store.get(pkg.Name)
+	return ok && v.Status == Built
+}
+
+func packageID(name string) string {
+	return "packagestore:`" + name + "`"
+}
+
+// Fields only SetHold writes. Holds are set by the server while the workers
+// write statuses, neither may overwrite the fields of the other.
+var holdFields = []string{"held", "holdreason"}
+
+// statusFields returns the fields of pkg that status updates write.
+func statusFields(pkg PackageInfo) (map[string]interface{}, error) {
+	data, err := json.Marshal(pkg)
+	if err != nil {
+		return nil, err
 	}
-	return false
+	fields := make(map[string]interface{})
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, err
+	}
+	delete(fields, "id")
+	for _, field := range holdFields {
+		delete(fields, field)
+	}
+	return fields, nil
 }
 
-func saveSingleToDb(pkg PackageInfo) error {
+// mergePackage merges fields into the record of a package, creating it if
+// needed.
+func mergePackage(name string, fields map[string]interface{}) error {
 	if dbInstance == nil {
 		var err error
 		dbInstance, err = db.New()
@@ -176,7 +273,17 @@ func saveSingleToDb(pkg PackageInfo) error {
 			return err
 		}
 	}
-	_, err := surrealdb.SmartMarshal(dbInstance.Update, pkg)
+	_, err := dbInstance.Change(packageID(name), fields)
+	return err
+}
+
+func saveSingleToDb(pkg PackageInfo) error {
+	defer metrics.ObserveSince(metrics.DBWriteDuration.WithLabelValues("package"), time.Now())
+	fields, err := statusFields(pkg)
+	if err != nil {
+		return err
+	}
+	err = mergePackage(pkg.Name, fields)
 	if err != nil {
 		fmt.Println(err)
 		return err
@@ -193,7 +300,8 @@ func saveSingleToDb(pkg PackageInfo) error {
 	return nil
 }
 
-func SaveToDb() error {
+func SaveToDb(updatedPackages []PackageInfo) error {
+	defer metrics.ObserveSince(metrics.DBWriteDuration.WithLabelValues("package_batch"), time.Now())
 	if dbInstance == nil {
 		var err error
 		dbInstance, err = db.New()
@@ -202,14 +310,17 @@ func SaveToDb() error {
 		}
 	}
 
-	for i, v := range updatedPackagesSlice {
-		id := "packagestore:`" + v.Name + "`"
-		v.ID = id
-		updatedPackagesSlice[i] = v
+	for i, v := range updatedPackages {
+		v.ID = packageID(v.Name)
+		updatedPackages[i] = v
 	}
 
-	for _, pkg := range updatedPackagesSlice {
-		_, err := surrealdb.SmartMarshal(dbInstance.Update, pkg)
+	for _, pkg := range updatedPackages {
+		fields, err := statusFields(pkg)
+		if err != nil {
+			return err
+		}
+		err = mergePackage(pkg.Name, fields)
 		if err != nil {
 			fmt.Println(err)
 			return err
@@ -243,16 +354,7 @@ func LoadFromDb() error {
 		slog.Error(err.Error())
 		return nil
 	}
-	packagesSlice = packages
-	slices.SortStableFunc(packagesSlice, func(a, b PackageInfo) int {
-		if a.Name == b.Name {
-			return 0
-		}
-		if a.Name > b.Name {
-			return 1
-		}
-		return -1
-	})
+	store.replaceAll(packages)
 	timecont, err := surrealdb.SmartUnmarshal[TimeContainer](dbInstance.Select("lastupdatetime:`lastupdatetime`"))
 	if err != nil {
 		slog.Error(err.Error())
@@ -266,6 +368,30 @@ func LoadFromDb() error {
 	return nil
 }
 
+// ReloadSource refreshes the packages of a source from the database. Other
+// processes may have queued, built or held them since the store was loaded.
+func ReloadSource(source string) error {
+	if dbInstance == nil {
+		var err error
+		dbInstance, err = db.New()
+		if err != nil {
+			return err
+		}
+	}
+	pkgs, err := surrealdb.SmartUnmarshal[[]PackageInfo](dbInstance.Query(
+		"SELECT * FROM packagestore WHERE source = $source OR (source = '' AND name = $source)",
+		map[string]interface{}{
+			"source": source,
+		}))
+	if err != nil {
+		return err
+	}
+	for _, pkg := range pkgs {
+		store.put(pkg)
+	}
+	return nil
+}
+
 func LoadInternalPackages(internalPackages map[string]PackageInfo) error {
 	localPackageFile := config.Configs.LocalPackageFiles
 	slices.SortStableFunc(localPackageFile, func(a, b config.PackageFile) int {
@@ -361,6 +487,7 @@ func ProcessStalePackages(internalPackages map[string]PackageInfo, externalPacka
 }
 
 func fetchPackageFile(pkg config.PackageFile, selectedRepo string) (map[string]PackageInfo, error) {
+	defer metrics.ObserveSince(metrics.FetchDuration.WithLabelValues(pkg.Name, selectedRepo), time.Now())
 	resp, err := http.Get(pkg.Url + selectedRepo + "/" + pkg.Packagepath + "." + pkg.Compression)
 	if err != nil {
 		return nil, err
@@ -448,36 +575,19 @@ func nameContains(name string, match []string) bool {
 }
 
 func GetPackagesCount() PackagesCount {
-	count := PackagesCount{
-		Stale:    0,
-		Missing:  0,
-		Built:    0,
-		Error:    0,
-		Queued:   0,
-		Building: 0,
-	}
-	for _, v := range packagesSlice {
-		switch v.Status {
-		case Stale:
-			count.Stale++
-		case Missing:
-			count.Missing++
-		case Built:
-			count.Built++
-		case Uptodate:
-			count.Built++
-		case Error:
-			count.Error++
-		case Queued:
-			count.Queued++
-		case Building:
-			count.Building++
-		}
-		if v.LastBuildStatus == Error {
-			count.Error++
-		}
+	return PackagesCount{
+		Stale:    store.countStatus(Stale),
+		Missing:  store.countStatus(Missing),
+		Built:    store.countStatus(Built) + store.countStatus(Uptodate),
+		Error:    store.countBuildStatus(Error),
+		Queued:   store.countStatus(Queued),
+		Building: store.countStatus(Building),
 	}
-	return count
+}
+
+// CountStatus returns the number of packages with the given current status.
+func CountStatus(status PackageStatus) int {
+	return store.countStatus(status)
 }
 
 type PackagesCount struct {
@@ -511,10 +621,37 @@ type PackageInfo struct {
 	PendingVersion string `json:"pendingversion"`
 	// Last Built Status
 	LastBuildStatus PackageStatus `json:"buildstatusinfo"`
+	// Why the last build failed
+	LastBuildError string `json:"lastbuilderror"`
+	// Outcome of the last reproducibility check of the source
+	Reproducibility string `json:"reproducibility"`
+	// Time of the last status change
+	StatusChangedAt time.Time `json:"statuschangedat"`
+	// Most recent status changes
+	StatusHistory []StatusTransition `json:"statushistory"`
+	// Held packages keep their source out of the build queue
+	Held       bool   `json:"held"`
+	HoldReason string `json:"holdreason"`
+	// Someone asked for a rebuild, fetches keep the package stale until a
+	// build of it finishes
+	RebuildRequested bool `json:"rebuildrequested"`
 }
 
 type PackageStatus string
 
+// Statuses lists every package status.
+var Statuses = []PackageStatus{Built, Stale, Error, Queued, Building, Missing, Uptodate}
+
+// ParsePackageStatus matches a status name case insensitively.
+func ParsePackageStatus(name string) (PackageStatus, bool) {
+	for _, status := range Statuses {
+		if strings.EqualFold(string(status), name) {
+			return status, true
+		}
+	}
+	return "", false
+}
+
 const (
 	// Package is built
 	Built PackageStatus = "Built"
@@ -0,0 +1,168 @@
+package packages
+
+import (
+	"fmt"
+	"log/slog"
+	"pkbldr/events"
+	"time"
+)
+
+// PackageEvent is something that happened to a package and may move it to
+// another status.
+type PackageEvent string
+
+const (
+	// The package indexes report a different status for the package
+	EventIndexChanged PackageEvent = "IndexChanged"
+	// The package was put in the build queue
+	EventQueued PackageEvent = "Queued"
+	// A builder picked the package up
+	EventBuildStarted PackageEvent = "BuildStarted"
+	// The build produced usable artifacts
+	EventBuildSucceeded PackageEvent = "BuildSucceeded"
+	// The build failed
+	EventBuildFailed PackageEvent = "BuildFailed"
+	// The build was called off before it finished
+	EventCancelled PackageEvent = "Cancelled"
+	// Someone asked for the package to be built again
+	EventRebuildRequested PackageEvent = "RebuildRequested"
+)
+
+// Records status changes in the event log, replaced in tests
+var recordStatusChange = events.RecordStatusChange
+
+// Number of transitions kept on each package record
+const maxStatusHistory = 20
+
+// StatusTransition records a single status change of a package.
+type StatusTransition struct {
+	From  PackageStatus `json:"from"`
+	To    PackageStatus `json:"to"`
+	Event PackageEvent  `json:"event"`
+	Time  time.Time     `json:"time"`
+}
+
+// IllegalTransitionError is returned when an event can't move a package to
+// the requested status.
+type IllegalTransitionError struct {
+	Package string
+	From    PackageStatus
+	To      PackageStatus
+	Event   PackageEvent
+}
+
+func (e *IllegalTransitionError) Error() string {
+	return fmt.Sprintf("illegal status transition for %s: %s -> %s on %s", e.Package, e.From, e.To, e.Event)
+}
+
+// StateMachine declares which events may move a package between statuses.
+type StateMachine struct {
+	transitions map[PackageStatus]map[PackageEvent][]PackageStatus
+}
+
+func NewStateMachine() *StateMachine {
+	return &StateMachine{
+		transitions: make(map[PackageStatus]map[PackageEvent][]PackageStatus),
+	}
+}
+
+// Allow declares that event moves a package in status from to any of to.
+func (sm *StateMachine) Allow(from PackageStatus, event PackageEvent, to ...PackageStatus) *StateMachine {
+	events, ok := sm.transitions[from]
+	if !ok {
+		events = make(map[PackageEvent][]PackageStatus)
+		sm.transitions[from] = events
+	}
+	events[event] = append(events[event], to...)
+	return sm
+}
+
+func (sm *StateMachine) CanTransition(from PackageStatus, event PackageEvent, to PackageStatus) bool {
+	for _, status := range sm.transitions[from][event] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Transition moves pkg to status to, recording the change on the package and
+// in the event log. Illegal transitions are logged and leave the package
+// untouched.
+func (sm *StateMachine) Transition(pkg *PackageInfo, event PackageEvent, to PackageStatus, actor events.Actor, reason string) error {
+	if !sm.CanTransition(pkg.Status, event, to) {
+		err := &IllegalTransitionError{
+			Package: pkg.Name,
+			From:    pkg.Status,
+			To:      to,
+			Event:   event,
+		}
+		slog.Warn(err.Error())
+		return err
+	}
+
+	if reason == "" {
+		reason = string(event)
+	}
+	recordStatusChange(pkg.Name, actor, string(pkg.Status), string(to), reason)
+
+	now := time.Now()
+	pkg.StatusHistory = append(pkg.StatusHistory, StatusTransition{
+		From:  pkg.Status,
+		To:    to,
+		Event: event,
+		Time:  now,
+	})
+	if len(pkg.StatusHistory) > maxStatusHistory {
+		pkg.StatusHistory = pkg.StatusHistory[len(pkg.StatusHistory)-maxStatusHistory:]
+	}
+	pkg.Status = to
+	pkg.StatusChangedAt = now
+	return nil
+}
+
+// StatusMachine holds the transitions allowed for every package.
+var StatusMachine = NewStateMachine().
+	Allow(Uptodate, EventIndexChanged, Stale, Missing).
+	Allow(Built, EventIndexChanged, Stale, Missing, Uptodate).
+	Allow(Error, EventIndexChanged, Stale, Missing, Uptodate).
+	Allow(Stale, EventIndexChanged, Missing, Uptodate).
+	Allow(Missing, EventIndexChanged, Stale, Uptodate).
+	Allow(Stale, EventQueued, Queued).
+	Allow(Missing, EventQueued, Queued).
+	// Builds interrupted by a worker restart are queued again
+	Allow(Building, EventQueued, Queued).
+	Allow(Queued, EventBuildStarted, Building).
+	Allow(Queued, EventBuildFailed, Error).
+	Allow(Building, EventBuildSucceeded, Uptodate).
+	Allow(Building, EventBuildFailed, Error).
+	Allow(Queued, EventCancelled, Stale, Missing).
+	Allow(Building, EventCancelled, Stale, Missing).
+	Allow(Uptodate, EventRebuildRequested, Stale).
+	Allow(Built, EventRebuildRequested, Stale).
+	Allow(Error, EventRebuildRequested, Stale)
+
+// Transition moves pkg to status to using StatusMachine.
+func Transition(pkg *PackageInfo, event PackageEvent, to PackageStatus, actor events.Actor, reason string) error {
+	return StatusMachine.Transition(pkg, event, to, actor, reason)
+}
+
+// PreQueueStatus returns the status a queued or building package had before
+// it was queued.
+func PreQueueStatus(pkg PackageInfo) PackageStatus {
+	for i := len(pkg.StatusHistory) - 1; i >= 0; i-- {
+		transition := pkg.StatusHistory[i]
+		if transition.Event == EventQueued && !IsInProgress(transition.From) {
+			return transition.From
+		}
+	}
+	if pkg.Version == "" {
+		return Missing
+	}
+	return Stale
+}
+
+// IsInProgress reports whether a build currently owns the package status.
+func IsInProgress(status PackageStatus) bool {
+	return status == Queued || status == Building
+}
@@ -0,0 +1,189 @@
+package packages
+
+import (
+	"errors"
+	"pkbldr/events"
+	"testing"
+)
+
+type transitionCase struct {
+	from  PackageStatus
+	event PackageEvent
+	to    PackageStatus
+	want  bool
+}
+
+func checkTransitions(t *testing.T, tests []transitionCase) {
+	t.Helper()
+	for _, tt := range tests {
+		got := StatusMachine.CanTransition(tt.from, tt.event, tt.to)
+		if got != tt.want {
+			t.Errorf("CanTransition(%s, %s, %s) = %v, want %v", tt.from, tt.event, tt.to, got, tt.want)
+		}
+	}
+}
+
+// recordedChange is a status change the stubbed event log received.
+type recordedChange struct {
+	pkg, before, after, reason string
+}
+
+// stubRecorder keeps Transition off the database for the test.
+func stubRecorder(t *testing.T) *[]recordedChange {
+	t.Helper()
+	recorded := &[]recordedChange{}
+	record := recordStatusChange
+	t.Cleanup(func() { recordStatusChange = record })
+	recordStatusChange = func(pkg string, actor events.Actor, before, after, reason string) {
+		*recorded = append(*recorded, recordedChange{pkg, before, after, reason})
+	}
+	return recorded
+}
+
+func TestStatusMachineTransitions(t *testing.T) {
+	checkTransitions(t, []transitionCase{
+		{Stale, EventQueued, Queued, true},
+		{Missing, EventQueued, Queued, true},
+		{Uptodate, EventQueued, Queued, false},
+		{Queued, EventBuildStarted, Building, true},
+		{Stale, EventBuildStarted, Building, false},
+		{Building, EventBuildSucceeded, Uptodate, true},
+		{Queued, EventBuildSucceeded, Uptodate, false},
+		{Building, EventBuildFailed, Error, true},
+		{Queued, EventBuildFailed, Error, true},
+		{Uptodate, EventIndexChanged, Stale, true},
+		{Error, EventIndexChanged, Uptodate, true},
+		{Queued, EventIndexChanged, Uptodate, false},
+		{Building, EventIndexChanged, Stale, false},
+		{Stale, EventIndexChanged, Stale, false},
+	})
+}
+
+func TestTransition(t *testing.T) {
+	recorded := stubRecorder(t)
+	sm := NewStateMachine().Allow(Stale, EventQueued, Queued)
+	pkg := PackageInfo{Name: "hello", Status: Stale}
+
+	err := sm.Transition(&pkg, EventQueued, Queued, events.SchedulerActor(), "")
+	if err != nil {
+		t.Fatalf("Transition() = %v", err)
+	}
+	if pkg.Status != Queued {
+		t.Errorf("status = %s, want %s", pkg.Status, Queued)
+	}
+	if len(pkg.StatusHistory) != 1 || pkg.StatusHistory[0] != (StatusTransition{From: Stale, To: Queued, Event: EventQueued, Time: pkg.StatusHistory[0].Time}) {
+		t.Errorf("history = %+v", pkg.StatusHistory)
+	}
+	want := recordedChange{"hello", string(Stale), string(Queued), string(EventQueued)}
+	if len(*recorded) != 1 || (*recorded)[0] != want {
+		t.Errorf("recorded %+v, want %+v", *recorded, want)
+	}
+
+	err = sm.Transition(&pkg, EventBuildStarted, Building, events.SchedulerActor(), "")
+	var illegal *IllegalTransitionError
+	if !errors.As(err, &illegal) {
+		t.Fatalf("Transition() = %v, want an IllegalTransitionError", err)
+	}
+	if pkg.Status != Queued || len(pkg.StatusHistory) != 1 || len(*recorded) != 1 {
+		t.Errorf("illegal transition changed the package or the log: %+v", pkg)
+	}
+}
+
+func TestTransitionTrimsHistory(t *testing.T) {
+	stubRecorder(t)
+	sm := NewStateMachine().
+		Allow(Stale, EventIndexChanged, Missing).
+		Allow(Missing, EventIndexChanged, Stale)
+	pkg := PackageInfo{Name: "hello", Status: Stale}
+	for i := 0; i < maxStatusHistory; i++ {
+		sm.Transition(&pkg, EventIndexChanged, Missing, events.SchedulerActor(), "")
+		sm.Transition(&pkg, EventIndexChanged, Stale, events.SchedulerActor(), "")
+	}
+	if len(pkg.StatusHistory) != maxStatusHistory {
+		t.Errorf("history length = %d, want %d", len(pkg.StatusHistory), maxStatusHistory)
+	}
+	if last := pkg.StatusHistory[len(pkg.StatusHistory)-1]; last.From != Missing || last.To != Stale {
+		t.Errorf("last transition = %+v, want the newest", last)
+	}
+}
+
+func TestRequeueTransitions(t *testing.T) {
+	checkTransitions(t, []transitionCase{
+		{Building, EventQueued, Queued, true},
+		{Queued, EventQueued, Queued, false},
+	})
+}
+
+func TestCancelTransitions(t *testing.T) {
+	checkTransitions(t, []transitionCase{
+		{Queued, EventCancelled, Stale, true},
+		{Queued, EventCancelled, Missing, true},
+		{Building, EventCancelled, Missing, true},
+		{Building, EventCancelled, Uptodate, false},
+		{Stale, EventCancelled, Missing, false},
+	})
+}
+
+func TestPreQueueStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		pkg  PackageInfo
+		want PackageStatus
+	}{
+		{
+			name: "queued from missing",
+			pkg: PackageInfo{Status: Queued, Version: "1.0", StatusHistory: []StatusTransition{
+				{From: Missing, To: Queued, Event: EventQueued},
+			}},
+			want: Missing,
+		},
+		{
+			name: "requeued while building",
+			pkg: PackageInfo{Status: Queued, Version: "1.0", StatusHistory: []StatusTransition{
+				{From: Stale, To: Queued, Event: EventQueued},
+				{From: Queued, To: Building, Event: EventBuildStarted},
+				{From: Building, To: Queued, Event: EventQueued},
+			}},
+			want: Stale,
+		},
+		{
+			name: "latest queueing wins",
+			pkg: PackageInfo{Status: Building, Version: "1.0", StatusHistory: []StatusTransition{
+				{From: Missing, To: Queued, Event: EventQueued},
+				{From: Queued, To: Building, Event: EventBuildStarted},
+				{From: Building, To: Uptodate, Event: EventBuildSucceeded},
+				{From: Uptodate, To: Stale, Event: EventIndexChanged},
+				{From: Stale, To: Queued, Event: EventQueued},
+				{From: Queued, To: Building, Event: EventBuildStarted},
+			}},
+			want: Stale,
+		},
+		{
+			name: "no history with a version",
+			pkg:  PackageInfo{Status: Queued, Version: "1.0"},
+			want: Stale,
+		},
+		{
+			name: "no history without a version",
+			pkg:  PackageInfo{Status: Queued},
+			want: Missing,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PreQueueStatus(tt.pkg); got != tt.want {
+				t.Errorf("PreQueueStatus() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRebuildTransitions(t *testing.T) {
+	checkTransitions(t, []transitionCase{
+		{Uptodate, EventRebuildRequested, Stale, true},
+		{Built, EventRebuildRequested, Stale, true},
+		{Error, EventRebuildRequested, Stale, true},
+		{Building, EventRebuildRequested, Stale, false},
+		{Uptodate, EventRebuildRequested, Missing, false},
+	})
+}
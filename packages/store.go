@@ -0,0 +1,200 @@
+package packages
+
+import (
+	"slices"
+	"sync"
+)
+
+// packageStore holds the known packages indexed by name, with secondary
+// indexes by source and by status so lookups never scan the whole archive.
+type packageStore struct {
+	mu            sync.RWMutex
+	byName        map[string]PackageInfo
+	bySource      map[string]map[string]struct{}
+	byStatus      map[PackageStatus]map[string]struct{}
+	byBuildStatus map[PackageStatus]map[string]struct{}
+	// sorted caches the name ordered view, rebuilt lazily after a change
+	sorted []PackageInfo
+}
+
+var store = newPackageStore()
+
+func newPackageStore() *packageStore {
+	return &packageStore{
+		byName:        make(map[string]PackageInfo),
+		bySource:      make(map[string]map[string]struct{}),
+		byStatus:      make(map[PackageStatus]map[string]struct{}),
+		byBuildStatus: make(map[PackageStatus]map[string]struct{}),
+	}
+}
+
+func sourceKey(pkg PackageInfo) string {
+	if pkg.Source == "" {
+		return pkg.Name
+	}
+	return pkg.Source
+}
+
+func addToIndex[K comparable](index map[K]map[string]struct{}, key K, name string) {
+	names, ok := index[key]
+	if !ok {
+		names = make(map[string]struct{})
+		index[key] = names
+	}
+	names[name] = struct{}{}
+}
+
+func removeFromIndex[K comparable](index map[K]map[string]struct{}, key K, name string) {
+	names, ok := index[key]
+	if !ok {
+		return
+	}
+	delete(names, name)
+	if len(names) == 0 {
+		delete(index, key)
+	}
+}
+
+// replaceAll swaps the whole content of the store.
+func (s *packageStore) replaceAll(pkgs []PackageInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.byName = make(map[string]PackageInfo, len(pkgs))
+	s.bySource = make(map[string]map[string]struct{})
+	s.byStatus = make(map[PackageStatus]map[string]struct{})
+	s.byBuildStatus = make(map[PackageStatus]map[string]struct{})
+	s.sorted = nil
+	for _, pkg := range pkgs {
+		s.putLocked(pkg)
+	}
+}
+
+// put inserts or replaces a package and reports whether it already existed.
+func (s *packageStore) put(pkg PackageInfo) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.putLocked(pkg)
+}
+
+func (s *packageStore) putLocked(pkg PackageInfo) bool {
+	old, existed := s.byName[pkg.Name]
+	if existed {
+		removeFromIndex(s.bySource, sourceKey(old), old.Name)
+		removeFromIndex(s.byStatus, old.Status, old.Name)
+		removeFromIndex(s.byBuildStatus, old.LastBuildStatus, old.Name)
+	}
+	s.byName[pkg.Name] = pkg
+	addToIndex(s.bySource, sourceKey(pkg), pkg.Name)
+	addToIndex(s.byStatus, pkg.Status, pkg.Name)
+	addToIndex(s.byBuildStatus, pkg.LastBuildStatus, pkg.Name)
+	s.sorted = nil
+	return existed
+}
+
+func (s *packageStore) get(name string) (PackageInfo, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pkg, ok := s.byName[name]
+	return pkg, ok
+}
+
+func (s *packageStore) len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.byName)
+}
+
+// all returns every package ordered by name. The result is a copy of the
+// cached view, callers may change it.
+func (s *packageStore) all() []PackageInfo {
+	s.mu.RLock()
+	sorted := s.sorted
+	s.mu.RUnlock()
+	if sorted != nil {
+		return slices.Clone(sorted)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sorted == nil {
+		sorted = make([]PackageInfo, 0, len(s.byName))
+		for _, pkg := range s.byName {
+			sorted = append(sorted, pkg)
+		}
+		sortByName(sorted)
+		s.sorted = sorted
+	}
+	return slices.Clone(s.sorted)
+}
+
+func (s *packageStore) collect(names map[string]struct{}) []PackageInfo {
+	pkgs := make([]PackageInfo, 0, len(names))
+	for name := range names {
+		pkgs = append(pkgs, s.byName[name])
+	}
+	sortByName(pkgs)
+	return pkgs
+}
+
+func (s *packageStore) withSource(source string) []PackageInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.collect(s.bySource[source])
+}
+
+// withStatus returns the packages whose status or last build status matches.
+func (s *packageStore) withStatus(status PackageStatus) []PackageInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make(map[string]struct{}, len(s.byStatus[status])+len(s.byBuildStatus[status]))
+	for name := range s.byStatus[status] {
+		names[name] = struct{}{}
+	}
+	for name := range s.byBuildStatus[status] {
+		names[name] = struct{}{}
+	}
+	return s.collect(names)
+}
+
+func (s *packageStore) countStatus(status PackageStatus) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.byStatus[status])
+}
+
+func (s *packageStore) countBuildStatus(status PackageStatus) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.byBuildStatus[status])
+}
+
+// sourcesWithStatus groups the packages in one of the given statuses by
+// their source package.
+func (s *packageStore) sourcesWithStatus(statuses ...PackageStatus) map[string][]PackageInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	groups := make(map[string][]PackageInfo)
+	for _, status := range statuses {
+		for name := range s.byStatus[status] {
+			pkg := s.byName[name]
+			key := sourceKey(pkg)
+			groups[key] = append(groups[key], pkg)
+		}
+	}
+	for _, pkgs := range groups {
+		sortByName(pkgs)
+	}
+	return groups
+}
+
+func sortByName(pkgs []PackageInfo) {
+	slices.SortStableFunc(pkgs, func(a, b PackageInfo) int {
+		if a.Name == b.Name {
+			return 0
+		}
+		if a.Name > b.Name {
+			return 1
+		}
+		return -1
+	})
+}
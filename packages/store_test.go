@@ -0,0 +1,22 @@
+package packages
+
+import "testing"
+
+func TestStoreAllReturnsCopy(t *testing.T) {
+	s := newPackageStore()
+	s.put(PackageInfo{Name: "b"})
+	s.put(PackageInfo{Name: "a"})
+
+	for i := 0; i < 2; i++ {
+		// The first call builds the cached view, the second reads it
+		pkgs := s.all()
+		if len(pkgs) != 2 || pkgs[0].Name != "a" || pkgs[1].Name != "b" {
+			t.Fatalf("all() = %v, want a, b", pkgs)
+		}
+		pkgs[0].Name = "changed"
+		pkgs[0], pkgs[1] = pkgs[1], pkgs[0]
+	}
+	if pkgs := s.all(); pkgs[0].Name != "a" || pkgs[1].Name != "b" {
+		t.Errorf("all() = %v after changing earlier results, want a, b", pkgs)
+	}
+}
@@ -0,0 +1,348 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"pkbldr/config"
+	"pkbldr/db"
+	"pkbldr/deb"
+	"pkbldr/events"
+	"sync"
+	"time"
+
+	"github.com/surrealdb/surrealdb.go"
+)
+
+var dbInstance *surrealdb.DB
+var dbLock sync.Mutex
+
+type PromotionStatus string
+
+const (
+	// Build is in the staging suite
+	Staged PromotionStatus = "Staged"
+	// Build was moved to the main suite
+	Promoted PromotionStatus = "Promoted"
+	// A newer build of the source was staged before this one got promoted
+	Superseded PromotionStatus = "Superseded"
+)
+
+var ErrNotStaged = errors.New("build is not staged")
+var ErrChecksFailed = errors.New("build failed its checks")
+
+type CheckResult string
+
+const (
+	// Check was not run
+	CheckSkipped CheckResult = ""
+	CheckPassed  CheckResult = "passed"
+	CheckFailed  CheckResult = "failed"
+)
+
+// BuildChecks holds the results of the post build checks of a build.
+type BuildChecks struct {
+	InstallCheck CheckResult `json:"installcheck"`
+	Autopkgtest  CheckResult `json:"autopkgtest"`
+}
+
+// Promotable reports whether the checks allow promoting the build.
+func (c BuildChecks) Promotable() bool {
+	if config.Configs.Repo.GateOnAutopkgtest && c.Autopkgtest == CheckFailed {
+		return false
+	}
+	return true
+}
+
+// Promotion tracks a single source version through the suites.
+type Promotion struct {
+	ID         string          `json:"id"`
+	Source     string          `json:"source"`
+	Version    string          `json:"version"`
+	Files      []string        `json:"files"`
+	Status     PromotionStatus `json:"status"`
+	Checks     BuildChecks     `json:"checks"`
+	StagedAt   time.Time       `json:"stagedat"`
+	PromotedAt time.Time       `json:"promotedat"`
+	PromotedBy string          `json:"promotedby"`
+}
+
+func promotionID(source, version string) string {
+	return "promotions:`" + source + "_" + version + "`"
+}
+
+func connect() error {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+	if dbInstance != nil {
+		return nil
+	}
+	var err error
+	dbInstance, err = db.New()
+	return err
+}
+
+func StagingEnabled() bool {
+	return Enabled() && config.Configs.Repo.Staging
+}
+
+func StagingSuite() string {
+	if config.Configs.Repo.StagingSuite != "" {
+		return config.Configs.Repo.StagingSuite
+	}
+	return config.Configs.Repo.Suite + "-proposed"
+}
+
+// Suites returns the suites that get published.
+func Suites() []string {
+	if StagingEnabled() {
+		return []string{config.Configs.Repo.Suite, StagingSuite()}
+	}
+	return []string{config.Configs.Repo.Suite}
+}
+
+// AddBuild adds the outputs of a successful build to the repository. With
+// staging enabled they land in the staging suite and wait for promotion.
+func AddBuild(source, version string, debFiles []string, dscFiles []string, checks BuildChecks) error {
+	suite := config.Configs.Repo.Suite
+	if StagingEnabled() {
+		suite = StagingSuite()
+	}
+
+	files := make([]string, 0)
+	err := AddBinaries(suite, source, debFiles)
+	if err != nil {
+		return err
+	}
+	for _, file := range debFiles {
+		files = append(files, filepath.Base(file))
+	}
+	for _, dscFile := range dscFiles {
+		err = AddSource(suite, source, dscFile)
+		if err != nil {
+			return err
+		}
+		stanza, err := deb.GetControlFileFromDsc(dscFile)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.Base(dscFile))
+		files = append(files, deb.SourceFiles(stanza)...)
+	}
+
+	if !StagingEnabled() {
+		return nil
+	}
+	return stage(source, version, files, checks)
+}
+
+func stage(source, version string, files []string, checks BuildChecks) error {
+	err := connect()
+	if err != nil {
+		return err
+	}
+
+	staged, err := ListPromotions(Staged)
+	if err != nil {
+		return err
+	}
+	for _, promotion := range staged {
+		if promotion.Source != source || promotion.Version == version {
+			continue
+		}
+		promotion.Status = Superseded
+		_, err = surrealdb.SmartMarshal(dbInstance.Update, promotion)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = surrealdb.SmartMarshal(dbInstance.Update, Promotion{
+		ID:       promotionID(source, version),
+		Source:   source,
+		Version:  version,
+		Files:    files,
+		Status:   Staged,
+		Checks:   checks,
+		StagedAt: time.Now().UTC(),
+	})
+	return err
+}
+
+func GetPromotion(source, version string) (Promotion, error) {
+	err := connect()
+	if err != nil {
+		return Promotion{}, err
+	}
+	return surrealdb.SmartUnmarshal[Promotion](dbInstance.Select(promotionID(source, version)))
+}
+
+// ListPromotions returns the builds in a promotion status, newest first.
+func ListPromotions(status PromotionStatus) ([]Promotion, error) {
+	err := connect()
+	if err != nil {
+		return nil, err
+	}
+	return surrealdb.SmartUnmarshal[[]Promotion](dbInstance.Query(
+		"SELECT * FROM promotions WHERE status = $status ORDER BY stagedat DESC LIMIT 500",
+		map[string]interface{}{
+			"status": status,
+		}))
+}
+
+// Promote moves a staged build to the main suite and republishes both
+// suites.
+func Promote(source, version string, actor events.Actor, reason string) error {
+	promotion, err := GetPromotion(source, version)
+	if err != nil {
+		return err
+	}
+	if promotion.Status != Staged {
+		return fmt.Errorf("%s %s: %w", source, version, ErrNotStaged)
+	}
+	if !promotion.Checks.Promotable() {
+		return fmt.Errorf("%s %s: %w", source, version, ErrChecksFailed)
+	}
+
+	staging := StagingSuite()
+	suite := config.Configs.Repo.Suite
+	err = moveFiles(staging, suite, source, promotion.Files)
+	if err != nil {
+		return err
+	}
+	err = exportBinaries(suite, source, promotion.Files)
+	if err != nil {
+		return err
+	}
+
+	promotion.Status = Promoted
+	promotion.PromotedAt = time.Now().UTC()
+	promotion.PromotedBy = actor.String()
+	_, err = surrealdb.SmartMarshal(dbInstance.Update, promotion)
+	if err != nil {
+		return err
+	}
+
+	events.Record(events.Event{
+		Kind:    events.Promotion,
+		Package: source,
+		Actor:   actor,
+		Field:   "suite",
+		Before:  staging,
+		After:   suite,
+		Reason:  reason + " (" + version + ")",
+	})
+
+	err = Publish(staging)
+	if err != nil {
+		return err
+	}
+	return Publish(suite)
+}
+
+func moveFiles(from, to, source string, files []string) error {
+	repoLock.Lock()
+	defer repoLock.Unlock()
+
+	root := config.Configs.Repo.Dir
+	srcDir := filepath.Join(root, poolDir(from, source))
+	dstDir := filepath.Join(root, poolDir(to, source))
+	err := os.MkdirAll(dstDir, 0755)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		err = os.Rename(filepath.Join(srcDir, file), filepath.Join(dstDir, file))
+		if os.IsNotExist(err) {
+			// Source tarballs may be shared with an older staged version
+			slog.Warn("staged file missing on promotion: " + file)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportBinaries copies the .deb files of a promoted build to the build
+// output directory. With staging enabled builds only get there once they
+// are promoted.
+func exportBinaries(suite, source string, files []string) error {
+	if config.Configs.DeboutputDir == "" {
+		return nil
+	}
+	repoLock.Lock()
+	defer repoLock.Unlock()
+
+	dir := filepath.Join(config.Configs.Repo.Dir, poolDir(suite, source))
+	for _, file := range files {
+		if filepath.Ext(file) != ".deb" {
+			continue
+		}
+		err := copyFile(filepath.Join(dir, file), filepath.Join(config.Configs.DeboutputDir, file))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Keeps concurrent builds from promoting the same staged build twice
+var promoteLock sync.Mutex
+
+// PromoteDue promotes every staged build whose soak period has elapsed, or
+// whose install check passed when promoteOnInstallCheck is set.
+func PromoteDue() error {
+	if !StagingEnabled() {
+		return nil
+	}
+	var soak time.Duration
+	if config.Configs.Repo.SoakPeriod != "" {
+		var err error
+		soak, err = time.ParseDuration(config.Configs.Repo.SoakPeriod)
+		if err != nil {
+			return fmt.Errorf("invalid soak period: %w", err)
+		}
+	}
+	onInstallCheck := config.Configs.Repo.PromoteOnInstallCheck
+	if soak == 0 && !onInstallCheck {
+		return nil
+	}
+
+	promoteLock.Lock()
+	defer promoteLock.Unlock()
+	staged, err := ListPromotions(Staged)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, promotion := range staged {
+		reason, due := promotionDue(promotion, now, soak, onInstallCheck)
+		if !due {
+			continue
+		}
+		err = Promote(promotion.Source, promotion.Version, events.SchedulerActor(), reason)
+		if err != nil {
+			slog.Error("unable to promote " + promotion.Source + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// promotionDue reports whether a staged build is due for automatic
+// promotion and why. A zero soak period never elapses.
+func promotionDue(promotion Promotion, now time.Time, soak time.Duration, onInstallCheck bool) (string, bool) {
+	if !promotion.Checks.Promotable() {
+		return "", false
+	}
+	if onInstallCheck && promotion.Checks.InstallCheck == CheckPassed {
+		return "install check passed", true
+	}
+	if soak > 0 && now.Sub(promotion.StagedAt) >= soak {
+		return "soak period elapsed", true
+	}
+	return "", false
+}
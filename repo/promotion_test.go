@@ -0,0 +1,135 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"pkbldr/config"
+	"testing"
+	"time"
+)
+
+func TestPromotable(t *testing.T) {
+	tests := []struct {
+		name   string
+		gate   bool
+		checks BuildChecks
+		want   bool
+	}{
+		{"no checks run", true, BuildChecks{}, true},
+		{"autopkgtest passed", true, BuildChecks{Autopkgtest: CheckPassed}, true},
+		{"autopkgtest failed and gated", true, BuildChecks{Autopkgtest: CheckFailed}, false},
+		{"autopkgtest failed and not gated", false, BuildChecks{Autopkgtest: CheckFailed}, true},
+		{"install check failed", true, BuildChecks{InstallCheck: CheckFailed}, true},
+	}
+	defer func(repo config.RepoConfig) { config.Configs.Repo = repo }(config.Configs.Repo)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Configs.Repo.GateOnAutopkgtest = tt.gate
+			if got := tt.checks.Promotable(); got != tt.want {
+				t.Errorf("Promotable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPromotionDue(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	staged := func(age time.Duration, checks BuildChecks) Promotion {
+		return Promotion{Status: Staged, StagedAt: now.Add(-age), Checks: checks}
+	}
+	tests := []struct {
+		name           string
+		promotion      Promotion
+		soak           time.Duration
+		onInstallCheck bool
+		wantReason     string
+		wantDue        bool
+	}{
+		{
+			name:      "soaking",
+			promotion: staged(time.Hour, BuildChecks{}),
+			soak:      24 * time.Hour,
+		},
+		{
+			name:       "soak period elapsed",
+			promotion:  staged(24*time.Hour, BuildChecks{}),
+			soak:       24 * time.Hour,
+			wantReason: "soak period elapsed",
+			wantDue:    true,
+		},
+		{
+			name:      "no soak period",
+			promotion: staged(365*24*time.Hour, BuildChecks{}),
+		},
+		{
+			name:           "install check passed",
+			promotion:      staged(time.Minute, BuildChecks{InstallCheck: CheckPassed}),
+			soak:           24 * time.Hour,
+			onInstallCheck: true,
+			wantReason:     "install check passed",
+			wantDue:        true,
+		},
+		{
+			name:      "install check passed without promoting on it",
+			promotion: staged(time.Minute, BuildChecks{InstallCheck: CheckPassed}),
+			soak:      24 * time.Hour,
+		},
+		{
+			name:           "install check failed",
+			promotion:      staged(time.Minute, BuildChecks{InstallCheck: CheckFailed}),
+			onInstallCheck: true,
+		},
+		{
+			name:           "install check skipped",
+			promotion:      staged(time.Minute, BuildChecks{}),
+			onInstallCheck: true,
+		},
+		{
+			name:           "gated on failed autopkgtests",
+			promotion:      staged(48*time.Hour, BuildChecks{InstallCheck: CheckPassed, Autopkgtest: CheckFailed}),
+			soak:           24 * time.Hour,
+			onInstallCheck: true,
+		},
+	}
+	defer func(repo config.RepoConfig) { config.Configs.Repo = repo }(config.Configs.Repo)
+	config.Configs.Repo.GateOnAutopkgtest = true
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, due := promotionDue(tt.promotion, now, tt.soak, tt.onInstallCheck)
+			if due != tt.wantDue || reason != tt.wantReason {
+				t.Errorf("promotionDue() = %q, %v, want %q, %v", reason, due, tt.wantReason, tt.wantDue)
+			}
+		})
+	}
+}
+
+func TestExportBinaries(t *testing.T) {
+	defer func(c config.Config) { config.Configs = c }(config.Configs)
+	config.Configs.Repo = config.RepoConfig{Enabled: true, Dir: t.TempDir(), Suite: "nest", Staging: true}
+	config.Configs.DeboutputDir = t.TempDir()
+
+	pool := filepath.Join(config.Configs.Repo.Dir, poolDir("nest", "hello"))
+	err := os.MkdirAll(pool, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := []string{"hello_1.0_amd64.deb", "hello_1.0.dsc", "hello_1.0.tar.xz"}
+	for _, file := range files {
+		err = os.WriteFile(filepath.Join(pool, file), []byte(file), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = exportBinaries("nest", "hello", files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(config.Configs.DeboutputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "hello_1.0_amd64.deb" {
+		t.Errorf("exported %v, want only the .deb", entries)
+	}
+}
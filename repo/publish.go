@@ -0,0 +1,421 @@
+package repo
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"pkbldr/config"
+	"pkbldr/deb"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/ulikunitz/xz"
+	"pault.ag/go/debian/version"
+)
+
+// poolEntry is a package file in the pool with its parsed control stanza.
+type poolEntry struct {
+	path    string
+	size    int64
+	modTime time.Time
+	stanza  deb.Stanza
+	hashes  fileHashes
+}
+
+// Parsed pool files, keyed by path. Reading the control data of every .deb
+// on each publish would be far too slow for a full archive.
+var poolCache = make(map[string]poolEntry)
+
+func readPoolFile(file string, info fs.FileInfo, read func(string) (deb.Stanza, error)) (poolEntry, error) {
+	cached, ok := poolCache[file]
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached, nil
+	}
+
+	stanza, err := read(file)
+	if err != nil {
+		return poolEntry{}, err
+	}
+	hashes, err := hashFile(file)
+	if err != nil {
+		return poolEntry{}, err
+	}
+	entry := poolEntry{
+		path:    file,
+		size:    info.Size(),
+		modTime: info.ModTime(),
+		stanza:  stanza,
+		hashes:  hashes,
+	}
+	poolCache[file] = entry
+	return entry, nil
+}
+
+// isNewer reports whether a has a higher version than b.
+func isNewer(a, b poolEntry) bool {
+	verA, errA := version.Parse(a.stanza["Version"])
+	verB, errB := version.Parse(b.stanza["Version"])
+	if errA != nil || errB != nil {
+		return a.stanza["Version"] > b.stanza["Version"]
+	}
+	return version.Compare(verA, verB) > 0
+}
+
+// Publish regenerates the Packages, Sources and Release files of a suite
+// from the content of its pool. Only the newest version of each package is
+// indexed, superseded files are removed from the pool.
+func Publish(suite string) error {
+	repoLock.Lock()
+	defer repoLock.Unlock()
+
+	start := time.Now()
+	root := config.Configs.Repo.Dir
+	binaries := make(map[string]poolEntry)
+	sources := make(map[string]poolEntry)
+	superseded := make([]poolEntry, 0)
+
+	keep := func(entries map[string]poolEntry, key string, entry poolEntry) {
+		existing, ok := entries[key]
+		if !ok {
+			entries[key] = entry
+			return
+		}
+		if isNewer(entry, existing) {
+			entries[key] = entry
+			superseded = append(superseded, existing)
+		} else {
+			superseded = append(superseded, entry)
+		}
+	}
+
+	poolRoot := filepath.Join(root, "pool", suite)
+	err := filepath.WalkDir(poolRoot, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch filepath.Ext(file) {
+		case ".deb":
+			entry, err := readPoolFile(file, info, deb.GetControlFileFromDeb)
+			if err != nil {
+				slog.Error("skipping unreadable package: " + err.Error())
+				return nil
+			}
+			keep(binaries, entry.stanza["Package"]+"_"+entry.stanza["Architecture"], entry)
+		case ".dsc":
+			entry, err := readPoolFile(file, info, deb.GetControlFileFromDsc)
+			if err != nil {
+				slog.Error("skipping unreadable source: " + err.Error())
+				return nil
+			}
+			keep(sources, entry.stanza["Source"], entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	pruneSuperseded(superseded, sources)
+
+	distDir := filepath.Join(root, "dists", suite)
+	indexes := make([]string, 0)
+
+	for _, arch := range architectures() {
+		stanzas := make([]deb.Stanza, 0)
+		for _, entry := range sortedEntries(binaries) {
+			pkgArch := entry.stanza["Architecture"]
+			if pkgArch != arch && pkgArch != "all" {
+				continue
+			}
+			stanzas = append(stanzas, binaryStanza(root, entry))
+		}
+		written, err := writeIndex(distDir, filepath.Join(component(), "binary-"+arch, "Packages"), stanzas, false)
+		if err != nil {
+			return err
+		}
+		indexes = append(indexes, written...)
+	}
+
+	stanzas := make([]deb.Stanza, 0)
+	for _, entry := range sortedEntries(sources) {
+		stanzas = append(stanzas, sourceStanza(root, entry))
+	}
+	written, err := writeIndex(distDir, filepath.Join(component(), "source", "Sources"), stanzas, true)
+	if err != nil {
+		return err
+	}
+	indexes = append(indexes, written...)
+
+	err = writeRelease(distDir, suite, indexes)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Published %d binary and %d source packages to %s in %s\n", len(binaries), len(sources), suite, time.Since(start))
+	return nil
+}
+
+func architectures() []string {
+	if len(config.Configs.Repo.Architectures) == 0 {
+		return []string{config.Configs.Architecture()}
+	}
+	return config.Configs.Repo.Architectures
+}
+
+func sortedEntries(entries map[string]poolEntry) []poolEntry {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	sorted := make([]poolEntry, 0, len(keys))
+	for _, key := range keys {
+		sorted = append(sorted, entries[key])
+	}
+	return sorted
+}
+
+// pruneSuperseded removes older package files from the pool. Files of an
+// older source that the newest source still references are kept.
+func pruneSuperseded(superseded []poolEntry, sources map[string]poolEntry) {
+	for _, entry := range superseded {
+		delete(poolCache, entry.path)
+		if filepath.Ext(entry.path) == ".dsc" {
+			current := sources[entry.stanza["Source"]]
+			stillUsed := deb.SourceFiles(current.stanza)
+			for _, file := range deb.SourceFiles(entry.stanza) {
+				if filepath.Dir(current.path) == filepath.Dir(entry.path) && slices.Contains(stillUsed, file) {
+					continue
+				}
+				os.Remove(filepath.Join(filepath.Dir(entry.path), file))
+			}
+		}
+		err := os.Remove(entry.path)
+		if err != nil {
+			slog.Error(err.Error())
+		}
+	}
+}
+
+func relativePath(root, file string) string {
+	rel, err := filepath.Rel(root, file)
+	if err != nil {
+		return file
+	}
+	return filepath.ToSlash(rel)
+}
+
+func binaryStanza(root string, entry poolEntry) deb.Stanza {
+	stanza := entry.stanza.Copy()
+	stanza["Filename"] = relativePath(root, entry.path)
+	stanza["Size"] = strconv.FormatInt(entry.hashes.Size, 10)
+	stanza["MD5sum"] = entry.hashes.MD5
+	stanza["SHA1"] = entry.hashes.SHA1
+	stanza["SHA256"] = entry.hashes.SHA256
+	return stanza
+}
+
+func sourceStanza(root string, entry poolEntry) deb.Stanza {
+	stanza := entry.stanza.Copy()
+	stanza["Package"] = stanza["Source"]
+	delete(stanza, "Source")
+	stanza["Directory"] = relativePath(root, filepath.Dir(entry.path))
+
+	// The .dsc itself is part of the source package
+	name := filepath.Base(entry.path)
+	size := strconv.FormatInt(entry.hashes.Size, 10)
+	stanza["Files"] += " " + entry.hashes.MD5 + " " + size + " " + name + "\n"
+	if _, ok := stanza["Checksums-Sha1"]; ok {
+		stanza["Checksums-Sha1"] += " " + entry.hashes.SHA1 + " " + size + " " + name + "\n"
+	}
+	if _, ok := stanza["Checksums-Sha256"]; ok {
+		stanza["Checksums-Sha256"] += " " + entry.hashes.SHA256 + " " + size + " " + name + "\n"
+	}
+	if _, ok := stanza["Checksums-Sha512"]; ok {
+		stanza["Checksums-Sha512"] += " " + entry.hashes.SHA512 + " " + size + " " + name + "\n"
+	}
+	return stanza
+}
+
+// writeIndex writes an index file and its compressed variants, returning
+// their paths relative to the suite directory.
+func writeIndex(distDir, name string, stanzas []deb.Stanza, isSource bool) ([]string, error) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	for _, stanza := range stanzas {
+		err := stanza.WriteTo(w, isSource, false, false)
+		if err != nil {
+			return nil, err
+		}
+		_, err = w.WriteString("\n")
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := w.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(filepath.Join(distDir, filepath.Dir(name)), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	written := []string{name}
+	err = writeFileAtomic(filepath.Join(distDir, name), buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, compression := range config.Configs.Repo.Compression {
+		var compressed bytes.Buffer
+		var cw io.WriteCloser
+		switch compression {
+		case "gz":
+			cw = gzip.NewWriter(&compressed)
+		case "xz":
+			cw, err = xz.NewWriter(&compressed)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			slog.Warn("unsupported index compression: " + compression)
+			continue
+		}
+		_, err = cw.Write(buf.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		err = cw.Close()
+		if err != nil {
+			return nil, err
+		}
+		err = writeFileAtomic(filepath.Join(distDir, name+"."+compression), compressed.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		written = append(written, name+"."+compression)
+	}
+
+	return written, nil
+}
+
+func writeFileAtomic(file string, data []byte) error {
+	tmp := file + ".tmp"
+	err := os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+func writeRelease(distDir, suite string, indexes []string) error {
+	repoConfig := config.Configs.Repo
+	release := deb.Stanza{
+		"Suite":         suite,
+		"Codename":      suite,
+		"Date":          time.Now().UTC().Format("Mon, 02 Jan 2006 15:04:05 UTC"),
+		"Architectures": strings.Join(architectures(), " "),
+		"Components":    component(),
+	}
+	if suite == repoConfig.Suite && repoConfig.Codename != "" {
+		release["Codename"] = repoConfig.Codename
+	}
+	if repoConfig.Origin != "" {
+		release["Origin"] = repoConfig.Origin
+	}
+	if repoConfig.Label != "" {
+		release["Label"] = repoConfig.Label
+	}
+	if repoConfig.Description != "" {
+		// Description is written as a multiline field
+		release["Description"] = " " + repoConfig.Description
+	}
+
+	var md5s, sha1s, sha256s, sha512s strings.Builder
+	for _, index := range indexes {
+		hashes, err := hashFile(filepath.Join(distDir, index))
+		if err != nil {
+			return err
+		}
+		size := strconv.FormatInt(hashes.Size, 10)
+		fmt.Fprintf(&md5s, " %s %s %s\n", hashes.MD5, size, filepath.ToSlash(index))
+		fmt.Fprintf(&sha1s, " %s %s %s\n", hashes.SHA1, size, filepath.ToSlash(index))
+		fmt.Fprintf(&sha256s, " %s %s %s\n", hashes.SHA256, size, filepath.ToSlash(index))
+		fmt.Fprintf(&sha512s, " %s %s %s\n", hashes.SHA512, size, filepath.ToSlash(index))
+	}
+	release["MD5Sum"] = md5s.String()
+	release["SHA1"] = sha1s.String()
+	release["SHA256"] = sha256s.String()
+	release["SHA512"] = sha512s.String()
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	err := release.WriteTo(w, false, true, false)
+	if err != nil {
+		return err
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	releaseFile := filepath.Join(distDir, "Release")
+	err = writeFileAtomic(releaseFile, buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	return signRelease(distDir, releaseFile)
+}
+
+// signRelease creates InRelease and Release.gpg with the configured key.
+// Stale signatures are removed when signing is disabled.
+func signRelease(distDir, releaseFile string) error {
+	inRelease := filepath.Join(distDir, "InRelease")
+	releaseGpg := filepath.Join(distDir, "Release.gpg")
+	key := config.Configs.Repo.SigningKey
+	if key == "" {
+		os.Remove(inRelease)
+		os.Remove(releaseGpg)
+		return nil
+	}
+
+	cmd := exec.Command("gpg", "--batch", "--yes", "--local-user", key, "--clearsign", "-o", inRelease, releaseFile)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("unable to sign InRelease: %w", err)
+	}
+
+	cmd = exec.Command("gpg", "--batch", "--yes", "--local-user", key, "--armor", "--detach-sign", "-o", releaseGpg, releaseFile)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("unable to sign Release: %w", err)
+	}
+	return nil
+}
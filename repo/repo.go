@@ -0,0 +1,156 @@
+package repo
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"pkbldr/config"
+	"pkbldr/deb"
+	"strings"
+	"sync"
+)
+
+// Serialises changes to the pool and the published indexes
+var repoLock sync.Mutex
+
+func Enabled() bool {
+	repoConfig := config.Configs.Repo
+	return repoConfig.Enabled && repoConfig.Dir != "" && repoConfig.Suite != ""
+}
+
+func component() string {
+	if config.Configs.Repo.Component == "" {
+		return "main"
+	}
+	return config.Configs.Repo.Component
+}
+
+// poolDir returns the pool directory of a source package inside a suite,
+// relative to the repository root.
+func poolDir(suite, source string) string {
+	prefix := source[:1]
+	if strings.HasPrefix(source, "lib") && len(source) > 3 {
+		prefix = source[:4]
+	}
+	return filepath.Join("pool", suite, component(), prefix, source)
+}
+
+// AddBinaries copies built .deb files into the pool of the suite.
+func AddBinaries(suite, source string, debFiles []string) error {
+	repoLock.Lock()
+	defer repoLock.Unlock()
+
+	dir := filepath.Join(config.Configs.Repo.Dir, poolDir(suite, source))
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	for _, file := range debFiles {
+		err = copyFile(file, filepath.Join(dir, filepath.Base(file)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddSource copies a .dsc and every file it lists into the pool of the
+// suite.
+func AddSource(suite, source, dscFile string) error {
+	stanza, err := deb.GetControlFileFromDsc(dscFile)
+	if err != nil {
+		return err
+	}
+
+	repoLock.Lock()
+	defer repoLock.Unlock()
+
+	dir := filepath.Join(config.Configs.Repo.Dir, poolDir(suite, source))
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	srcDir := filepath.Dir(dscFile)
+	for _, file := range append(deb.SourceFiles(stanza), filepath.Base(dscFile)) {
+		err = copyFile(filepath.Join(srcDir, file), filepath.Join(dir, file))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := dst + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	err = out.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	err = os.Chmod(tmp, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+type fileHashes struct {
+	Size   int64
+	MD5    string
+	SHA1   string
+	SHA256 string
+	SHA512 string
+}
+
+func hashReader(rdr io.Reader) (fileHashes, error) {
+	md5sum := md5.New()
+	sha1sum := sha1.New()
+	sha256sum := sha256.New()
+	sha512sum := sha512.New()
+	size, err := io.Copy(io.MultiWriter(md5sum, sha1sum, sha256sum, sha512sum), rdr)
+	if err != nil {
+		return fileHashes{}, err
+	}
+	return fileHashes{
+		Size:   size,
+		MD5:    hex.EncodeToString(md5sum.Sum(nil)),
+		SHA1:   hex.EncodeToString(sha1sum.Sum(nil)),
+		SHA256: hex.EncodeToString(sha256sum.Sum(nil)),
+		SHA512: hex.EncodeToString(sha512sum.Sum(nil)),
+	}, nil
+}
+
+func hashFile(file string) (fileHashes, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return fileHashes{}, err
+	}
+	defer f.Close()
+	hashes, err := hashReader(f)
+	if err != nil {
+		return fileHashes{}, fmt.Errorf("unable to hash %s: %w", file, err)
+	}
+	return hashes, nil
+}
@@ -0,0 +1,168 @@
+package scheduler
+
+import (
+	"pkbldr/config"
+	"pkbldr/db"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/surrealdb/surrealdb.go"
+)
+
+var dbInstance *surrealdb.DB
+var dbLock sync.Mutex
+
+// How long finished jobs are kept
+const jobRetention = 7 * 24 * time.Hour
+
+// Kinds of jobs, named after the schedules starting them
+type JobKind string
+
+const (
+	FetchJob JobKind = config.ScheduleFetch
+	BuildJob JobKind = config.ScheduleBuild
+)
+
+type JobStatus string
+
+const (
+	Pending JobStatus = "pending"
+	Running JobStatus = "running"
+	Done    JobStatus = "done"
+	Failed  JobStatus = "failed"
+)
+
+// Job is a run of a fetch or build in the durable job queue.
+type Job struct {
+	ID     string    `json:"id"`
+	Kind   JobKind   `json:"kind"`
+	Status JobStatus `json:"status"`
+	Error  string    `json:"error"`
+	// The job doesn't start before this time
+	NotBefore  time.Time `json:"notbefore"`
+	CreatedAt  time.Time `json:"createdat"`
+	StartedAt  time.Time `json:"startedat"`
+	FinishedAt time.Time `json:"finishedat"`
+}
+
+func connect() error {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+	if dbInstance != nil {
+		return nil
+	}
+	var err error
+	dbInstance, err = db.New()
+	return err
+}
+
+func saveJob(job Job) error {
+	err := connect()
+	if err != nil {
+		return err
+	}
+	_, err = surrealdb.SmartMarshal(dbInstance.Update, job)
+	return err
+}
+
+func jobsWithStatus(status JobStatus) ([]Job, error) {
+	err := connect()
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := surrealdb.SmartUnmarshal[[]Job](dbInstance.Query(
+		"SELECT * FROM jobs WHERE status = $status",
+		map[string]interface{}{
+			"status": status,
+		}))
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+	})
+	return jobs, nil
+}
+
+// enqueue adds a job to the queue unless one of the same kind is already
+// waiting, that one covers the new request as well.
+func enqueue(kind JobKind, notBefore time.Time) error {
+	pending, err := jobsWithStatus(Pending)
+	if err != nil {
+		return err
+	}
+	for _, job := range pending {
+		if job.Kind == kind {
+			return nil
+		}
+	}
+
+	now := time.Now().UTC()
+	err = saveJob(Job{
+		ID:        "jobs:`" + string(kind) + "_" + strconv.FormatInt(now.UnixNano(), 10) + "`",
+		Kind:      kind,
+		Status:    Pending,
+		NotBefore: notBefore.UTC(),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return err
+	}
+	wake()
+	return nil
+}
+
+// nextJob returns the oldest pending job of a kind that may start now.
+func nextJob(kind JobKind) (Job, bool, error) {
+	pending, err := jobsWithStatus(Pending)
+	if err != nil {
+		return Job{}, false, err
+	}
+	now := time.Now()
+	for _, job := range pending {
+		if job.Kind == kind && !job.NotBefore.After(now) {
+			return job, true, nil
+		}
+	}
+	return Job{}, false, nil
+}
+
+// lastStarted returns when the last job of a kind started, zero when none
+// did.
+func lastStarted(kind JobKind) (time.Time, error) {
+	var last time.Time
+	for _, status := range []JobStatus{Running, Done, Failed} {
+		jobs, err := jobsWithStatus(status)
+		if err != nil {
+			return last, err
+		}
+		for _, job := range jobs {
+			if job.Kind == kind && job.StartedAt.After(last) {
+				last = job.StartedAt
+			}
+		}
+	}
+	return last, nil
+}
+
+// pruneJobs deletes finished jobs past the retention period.
+func pruneJobs() error {
+	for _, status := range []JobStatus{Done, Failed} {
+		jobs, err := jobsWithStatus(status)
+		if err != nil {
+			return err
+		}
+		for _, job := range jobs {
+			if time.Since(job.FinishedAt) < jobRetention {
+				continue
+			}
+			_, err = dbInstance.Delete(job.ID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,421 @@
+// Package scheduler runs the fetch and build jobs in process, for
+// deployments without Temporal.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"pkbldr/activities"
+	"pkbldr/config"
+	"pkbldr/db"
+	"pkbldr/events"
+	"pkbldr/packages"
+	"pkbldr/starters"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron"
+	"github.com/surrealdb/surrealdb.go"
+)
+
+// How often schedules and the job queue are checked
+const pollInterval = 30 * time.Second
+
+// Note of schedules paused by the configuration, tells them apart from
+// schedules paused by hand
+const configPauseNote = "paused in configuration"
+
+var kinds = []JobKind{FetchJob, BuildJob}
+
+var wakeCh = make(chan struct{}, 1)
+
+// Kinds of the jobs running in this process
+var running = make(map[JobKind]bool)
+var runningLock sync.Mutex
+
+// Job goroutines, Run waits for them before it returns
+var jobsWg sync.WaitGroup
+
+// scheduleState is the persisted state of a schedule.
+type scheduleState struct {
+	ID      string    `json:"id"`
+	Paused  bool      `json:"paused"`
+	Note    string    `json:"note"`
+	LastRun time.Time `json:"lastrun"`
+	NextRun time.Time `json:"nextrun"`
+}
+
+func stateID(kind JobKind) string {
+	return "schedulerstate:`" + string(kind) + "`"
+}
+
+// getState returns the persisted state of a schedule, a schedule that never
+// ran yet starts from a zero state.
+func getState(kind JobKind) (scheduleState, error) {
+	err := connect()
+	if err != nil {
+		return scheduleState{}, err
+	}
+	data, err := dbInstance.Select(stateID(kind))
+	return decodeState(kind, data, err)
+}
+
+func decodeState(kind JobKind, data interface{}, err error) (scheduleState, error) {
+	state, err := db.Record[scheduleState](data, err)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		return state, err
+	}
+	state.ID = stateID(kind)
+	return state, nil
+}
+
+func saveState(state scheduleState) error {
+	err := connect()
+	if err != nil {
+		return err
+	}
+	_, err = surrealdb.SmartMarshal(dbInstance.Update, state)
+	return err
+}
+
+// wake makes the loop look at the queue right away.
+func wake() {
+	select {
+	case wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// nextRun returns the first time a schedule is due after the given time.
+func nextRun(schedule config.ScheduleConfig, after time.Time) (time.Time, error) {
+	var next time.Time
+	if len(schedule.Cron) > 0 {
+		for _, expression := range schedule.Cron {
+			parsed, err := cron.ParseStandard(expression)
+			if err != nil {
+				return next, fmt.Errorf("invalid cron expression %s: %w", expression, err)
+			}
+			candidate := parsed.Next(after)
+			if next.IsZero() || candidate.Before(next) {
+				next = candidate
+			}
+		}
+	} else {
+		interval, err := time.ParseDuration(schedule.Interval)
+		if err != nil {
+			return next, fmt.Errorf("invalid interval: %w", err)
+		}
+		next = after.Add(interval)
+	}
+
+	if schedule.Jitter != "" {
+		jitter, err := time.ParseDuration(schedule.Jitter)
+		if err != nil {
+			return next, fmt.Errorf("invalid jitter: %w", err)
+		}
+		if jitter > 0 {
+			next = next.Add(time.Duration(rand.Int63n(int64(jitter))))
+		}
+	}
+	return next, nil
+}
+
+// Run runs the schedules and the job queue until the context ends, then
+// waits for the running jobs to wind down.
+func Run(ctx context.Context) {
+	err := recoverJobs()
+	if err != nil {
+		slog.Error("unable to recover interrupted jobs: " + err.Error())
+	}
+	err = SyncSchedules()
+	if err != nil {
+		slog.Error("unable to sync schedules: " + err.Error())
+	}
+	err = pruneJobs()
+	if err != nil {
+		slog.Error("unable to prune finished jobs: " + err.Error())
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		err = enqueueDue()
+		if err != nil {
+			slog.Error("unable to enqueue scheduled jobs: " + err.Error())
+		}
+		for _, kind := range kinds {
+			err = startNext(ctx, kind)
+			if err != nil {
+				slog.Error("unable to start " + string(kind) + " job: " + err.Error())
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			jobsWg.Wait()
+			return
+		case <-ticker.C:
+		case <-wakeCh:
+		}
+	}
+}
+
+// recoverJobs puts the jobs a previous process was running back in the
+// queue. The packages they had queued are reset by activities.ReconcileBuilds.
+func recoverJobs() error {
+	interrupted, err := jobsWithStatus(Running)
+	if err != nil {
+		return err
+	}
+	for _, job := range interrupted {
+		job.Status = Pending
+		err = saveJob(job)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SyncSchedules applies the configured pause state and timing to the
+// persisted schedules. Schedules paused by hand stay paused.
+func SyncSchedules() error {
+	for _, kind := range kinds {
+		schedule := config.Configs.Schedule(string(kind))
+		state, err := getState(kind)
+		if err != nil {
+			return err
+		}
+		if schedule.Paused && !state.Paused {
+			state.Paused = true
+			state.Note = configPauseNote
+		} else if !schedule.Paused && state.Paused && state.Note == configPauseNote {
+			state.Paused = false
+			state.Note = ""
+		}
+		// The timing may have changed
+		after := state.LastRun
+		if after.IsZero() {
+			after = time.Now()
+		}
+		state.NextRun, err = nextRun(schedule, after)
+		if err != nil {
+			return fmt.Errorf("%s schedule: %w", kind, err)
+		}
+		err = saveState(state)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enqueueDue queues a job for every schedule that is due. Runs missed while
+// the process was down are made up once.
+func enqueueDue() error {
+	now := time.Now()
+	for _, kind := range kinds {
+		state, err := getState(kind)
+		if err != nil {
+			return err
+		}
+		if state.Paused || state.NextRun.IsZero() || state.NextRun.After(now) {
+			continue
+		}
+		err = enqueue(kind, now)
+		if err != nil {
+			return err
+		}
+		state.LastRun = now
+		state.NextRun, err = nextRun(config.Configs.Schedule(string(kind)), now)
+		if err != nil {
+			return fmt.Errorf("%s schedule: %w", kind, err)
+		}
+		err = saveState(state)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startNext starts the next job of a kind unless one is running already.
+func startNext(ctx context.Context, kind JobKind) error {
+	runningLock.Lock()
+	defer runningLock.Unlock()
+	if running[kind] {
+		return nil
+	}
+	job, ok, err := nextJob(kind)
+	if err != nil || !ok {
+		return err
+	}
+	job.Status = Running
+	job.StartedAt = time.Now().UTC()
+	err = saveJob(job)
+	if err != nil {
+		return err
+	}
+	running[kind] = true
+
+	jobsWg.Add(1)
+	go func() {
+		defer jobsWg.Done()
+		err := runJob(ctx, job)
+		switch {
+		case ctx.Err() != nil:
+			// Interrupted by a shutdown, the next process runs it again
+			job.Status = Pending
+			job.StartedAt = time.Time{}
+		case err != nil:
+			slog.Error(string(job.Kind) + " job failed: " + err.Error())
+			job.Status = Failed
+			job.Error = err.Error()
+			job.FinishedAt = time.Now().UTC()
+		default:
+			job.Status = Done
+			job.FinishedAt = time.Now().UTC()
+		}
+		err = saveJob(job)
+		if err != nil {
+			slog.Error("unable to save job: " + err.Error())
+		}
+
+		runningLock.Lock()
+		running[kind] = false
+		runningLock.Unlock()
+		wake()
+	}()
+	return nil
+}
+
+func runJob(ctx context.Context, job Job) error {
+	switch job.Kind {
+	case FetchJob:
+		summary, err := activities.FetchPackages(ctx)
+		if err != nil {
+			return err
+		}
+		return chainBuild(ctx, summary)
+	case BuildJob:
+		err := activities.UpdateDockerContainer(ctx)
+		if err != nil {
+			return err
+		}
+		return activities.StartBuildLoop(ctx)
+	}
+	return fmt.Errorf("unknown job kind %s", job.Kind)
+}
+
+// chainBuild queues a build for the new work a fetch found. A build queued
+// or running already picks it up.
+func chainBuild(ctx context.Context, summary packages.FetchSummary) error {
+	plan, err := activities.PlanChainedBuild(ctx, summary)
+	if err != nil || !plan.Trigger {
+		return err
+	}
+
+	runningLock.Lock()
+	buildRunning := running[BuildJob]
+	runningLock.Unlock()
+	if !buildRunning && config.Configs.ChainBuild.MinInterval != "" {
+		minInterval, err := time.ParseDuration(config.Configs.ChainBuild.MinInterval)
+		if err != nil {
+			return fmt.Errorf("invalid minimum build interval: %w", err)
+		}
+		last, err := lastStarted(BuildJob)
+		if err != nil {
+			return err
+		}
+		if time.Since(last) < minInterval {
+			slog.Info("not queueing a build, the last one started less than " + minInterval.String() + " ago")
+			return nil
+		}
+	}
+	return enqueue(BuildJob, time.Now().Add(plan.Debounce))
+}
+
+func findKind(name string) (JobKind, error) {
+	for _, kind := range kinds {
+		if string(kind) == name {
+			return kind, nil
+		}
+	}
+	return "", fmt.Errorf("%s: %w", name, starters.ErrUnknownSchedule)
+}
+
+// ListSchedules returns the state of every schedule.
+func ListSchedules() ([]starters.ScheduleStatus, error) {
+	statuses := make([]starters.ScheduleStatus, 0, len(kinds))
+	for _, kind := range kinds {
+		state, err := getState(kind)
+		if err != nil {
+			return nil, err
+		}
+		status := starters.ScheduleStatus{
+			Name:    string(kind),
+			Spec:    config.Configs.Schedule(string(kind)).String(),
+			Paused:  state.Paused,
+			Note:    state.Note,
+			LastRun: state.LastRun,
+		}
+		if !state.NextRun.IsZero() {
+			status.NextRuns = []time.Time{state.NextRun}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func setPaused(name string, paused bool, actor events.Actor) error {
+	kind, err := findKind(name)
+	if err != nil {
+		return err
+	}
+	state, err := getState(kind)
+	if err != nil {
+		return err
+	}
+	state.Paused = paused
+	state.Note = "unpaused by " + actor.String()
+	if paused {
+		state.Note = "paused by " + actor.String()
+	}
+	return saveState(state)
+}
+
+// PauseSchedule stops a schedule from queueing jobs until it is unpaused.
+func PauseSchedule(name string, actor events.Actor) error {
+	err := setPaused(name, true, actor)
+	if err != nil {
+		return err
+	}
+	events.RecordManualAction("", actor, "schedule", "paused "+name+" schedule")
+	return nil
+}
+
+func UnpauseSchedule(name string, actor events.Actor) error {
+	err := setPaused(name, false, actor)
+	if err != nil {
+		return err
+	}
+	events.RecordManualAction("", actor, "schedule", "unpaused "+name+" schedule")
+	return nil
+}
+
+// TriggerSchedule queues a job of a schedule right away, paused or not.
+func TriggerSchedule(name string, actor events.Actor) error {
+	kind, err := findKind(name)
+	if err != nil {
+		return err
+	}
+	err = enqueue(kind, time.Now())
+	if err != nil {
+		return err
+	}
+	events.RecordManualAction("", actor, "schedule", "triggered "+name+" schedule")
+	return nil
+}
@@ -0,0 +1,131 @@
+package scheduler
+
+import (
+	"errors"
+	"pkbldr/config"
+	"testing"
+	"time"
+
+	"github.com/surrealdb/surrealdb.go"
+)
+
+func TestNextRun(t *testing.T) {
+	after := time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		schedule config.ScheduleConfig
+		want     time.Time
+		jitter   time.Duration
+		wantErr  bool
+	}{
+		{
+			name:     "interval",
+			schedule: config.ScheduleConfig{Interval: "6h"},
+			want:     after.Add(6 * time.Hour),
+		},
+		{
+			name:     "cron",
+			schedule: config.ScheduleConfig{Cron: []string{"0 12 * * *"}},
+			want:     time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "earliest of several cron expressions",
+			schedule: config.ScheduleConfig{Cron: []string{"0 3 * * *", "45 10 * * *", "0 12 * * *"}},
+			want:     time.Date(2024, 5, 1, 10, 45, 0, 0, time.UTC),
+		},
+		{
+			name:     "cron wins over interval",
+			schedule: config.ScheduleConfig{Cron: []string{"0 12 * * *"}, Interval: "1m"},
+			want:     time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "jitter",
+			schedule: config.ScheduleConfig{Interval: "1h", Jitter: "10m"},
+			want:     after.Add(time.Hour),
+			jitter:   10 * time.Minute,
+		},
+		{
+			name:     "zero jitter",
+			schedule: config.ScheduleConfig{Interval: "1h", Jitter: "0s"},
+			want:     after.Add(time.Hour),
+		},
+		{
+			name:     "invalid cron expression",
+			schedule: config.ScheduleConfig{Cron: []string{"every day"}},
+			wantErr:  true,
+		},
+		{
+			name:     "invalid interval",
+			schedule: config.ScheduleConfig{Interval: "daily"},
+			wantErr:  true,
+		},
+		{
+			name:     "invalid jitter",
+			schedule: config.ScheduleConfig{Interval: "1h", Jitter: "a bit"},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 20; i++ {
+				got, err := nextRun(tt.schedule, after)
+				if tt.wantErr {
+					if err == nil {
+						t.Fatalf("nextRun() = %v, want an error", got)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("nextRun() = %v", err)
+				}
+				if got.Before(tt.want) || (tt.jitter == 0 && !got.Equal(tt.want)) || (tt.jitter > 0 && !got.Before(tt.want.Add(tt.jitter))) {
+					t.Fatalf("nextRun() = %v, want %v plus up to %v", got, tt.want, tt.jitter)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeState(t *testing.T) {
+	lastRun := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	failed := errors.New("connection lost")
+	tests := []struct {
+		name    string
+		data    interface{}
+		err     error
+		want    scheduleState
+		wantErr error
+	}{
+		{
+			name: "never ran",
+			err:  surrealdb.ErrNoRow,
+			want: scheduleState{ID: stateID(BuildJob)},
+		},
+		{
+			name: "never ran, empty result",
+			data: []interface{}{},
+			want: scheduleState{ID: stateID(BuildJob)},
+		},
+		{
+			name: "paused",
+			data: map[string]interface{}{"id": "schedulerstate:build", "paused": true, "note": "paused by admin", "lastrun": lastRun.Format(time.RFC3339)},
+			want: scheduleState{ID: stateID(BuildJob), Paused: true, Note: "paused by admin", LastRun: lastRun},
+		},
+		{
+			name:    "lookup failed",
+			err:     failed,
+			wantErr: failed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeState(BuildJob, tt.data, tt.err)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("decodeState() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("decodeState() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -5,12 +5,7 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
-	"pkbldr/activities"
 	"pkbldr/auth"
-	"pkbldr/config"
-	"pkbldr/packages"
-	"pkbldr/starters"
-	"pkbldr/workflows"
 	"strconv"
 	"time"
 
@@ -18,12 +13,19 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/template/html/v2"
 	"go.temporal.io/sdk/client"
-	"go.temporal.io/sdk/worker"
 
 	gowebly "github.com/gowebly/helpers"
 )
 
+// Temporal client used by the handlers controlling running workflows, nil
+// with the builtin scheduler
+var temporalClient client.Client
+
+// How long open requests get to finish on shutdown
+const shutdownTimeout = 10 * time.Second
+
 // runServer runs a new HTTP server with the loaded environment variables.
+// The configuration must be loaded already.
 func runServer(ctx context.Context) error {
 	// Validate environment variables.
 	port, err := strconv.Atoi(gowebly.Getenv("BACKEND_PORT", "7555"))
@@ -32,13 +34,6 @@ func runServer(ctx context.Context) error {
 		return err
 	}
 
-	// Load configuration.
-	err = config.Init()
-	if err != nil {
-		slog.Error("unable to load configuration: " + err.Error())
-		return err
-	}
-
 	// Init session cache.
 	err = auth.Init()
 	if err != nil {
@@ -46,27 +41,6 @@ func runServer(ctx context.Context) error {
 		return err
 	}
 
-	err = packages.LoadFromDb()
-	if err != nil {
-		slog.Error("unable to load packages from db: " + err.Error())
-		return err
-	}
-
-	c, err := client.Dial(client.Options{
-		HostPort: config.Configs.TemporalUrl,
-	})
-	if err != nil {
-		fmt.Println("unable to create Temporal client: " + err.Error())
-	}
-	defer c.Close()
-
-	go startTemporalFetchWorker(c)
-	go startTemporalBuildWorker(c)
-	//go starters.FetchPackagesNow(c, ctx)
-	go starters.ScheduleFetchPackages(c, ctx)
-	//go starters.BuildPackagesNow(c, ctx)
-	go starters.ScheduleBuildPackages(c, ctx)
-
 	// Create a new server instance with options from environment variables.
 	// For more information, see https://blog.cloudflare.com/the-complete-guide-to-golang-net-http-timeouts/
 	config := fiber.Config{
@@ -90,33 +64,55 @@ func runServer(ctx context.Context) error {
 
 	server.Get("/packages", packagesPageHandler)
 
-	return server.Listen(fmt.Sprintf(":%d", port))
-}
-
-func startTemporalFetchWorker(c client.Client) {
-	// This worker hosts both Workflow and Activity functions
-	w := worker.New(c, workflows.PACKAGE_FETCH_TASK_QUEUE, worker.Options{})
-	w.RegisterWorkflow(workflows.FetchPackages)
-	w.RegisterActivity(activities.FetchPackages)
-
-	// Start listening to the Task Queue
-	err := w.Run(worker.InterruptCh())
-	if err != nil {
-		slog.Error("unable to start temporal fetch Worker: " + err.Error())
-	}
-}
-
-func startTemporalBuildWorker(c client.Client) {
-	// This worker hosts both Workflow and Activity functions
-	w := worker.New(c, workflows.PACKAGE_BUILD_TASK_QUEUE, worker.Options{})
-	w.RegisterWorkflow(workflows.BuildPackages)
-	w.RegisterActivity(activities.StartBuildLoop)
-	w.RegisterActivity(activities.UpdateDockerContainer)
-	w.RegisterActivity(activities.FetchPackages)
+	server.Get("/events", eventsPageHandler)
+
+	server.Get("/builds", buildsPageHandler)
+
+	server.Get("/run", runPageHandler)
+	server.Post("/run/:signal", requireUser, runSignalHandler)
+
+	server.Get("/schedules", schedulesPageHandler)
+	server.Post("/schedules/:name/:action", requireUser, scheduleActionHandler)
+
+	server.Get("/images", imagesPageHandler)
+	server.Post("/images/revert", requireUser, revertImageHandler)
+	server.Post("/images/unpin", requireUser, unpinImageHandler)
+
+	server.Get("/promotions", promotionsPageHandler)
+	server.Post("/promotions/promote", requireUser, promoteHandler)
+
+	registerMetrics()
+	server.Get("/metrics", metricsHandler)
+
+	server.Get("/login", loginPageHandler)
+	server.Post("/login", loginHandler)
+	server.Get("/logout", logoutHandler)
+
+	api := server.Group("/api")
+	api.Post("/login", apiLoginHandler)
+	api.Get("/packages", apiPackagesHandler)
+	api.Get("/packages/:name", apiPackageHandler)
+	api.Get("/packages/:name/log", apiBuildLogHandler)
+	api.Post("/packages/:name/hold", requireUser, apiHoldHandler)
+	api.Post("/packages/:name/unhold", requireUser, apiUnholdHandler)
+	api.Post("/packages/:name/rebuild", requireUser, apiRebuildHandler)
+	api.Get("/events", apiEventsHandler)
+	api.Get("/builds", apiBuildsHandler)
+	api.Get("/builds/:key/manifest", apiBuildManifestHandler)
+	api.Get("/run", apiRunHandler)
+	api.Post("/run/:signal", requireUser, apiRunSignalHandler)
+	api.Get("/schedules", apiSchedulesHandler)
+	api.Post("/schedules/:name/:action", requireUser, apiScheduleActionHandler)
+	api.Get("/promotions", apiPromotionsHandler)
+	api.Post("/promotions/promote", requireUser, apiPromoteHandler)
+
+	go func() {
+		<-ctx.Done()
+		err := server.ShutdownWithTimeout(shutdownTimeout)
+		if err != nil {
+			slog.Error("unable to shut the server down: " + err.Error())
+		}
+	}()
 
-	// Start listening to the Task Queue
-	err := w.Run(worker.InterruptCh())
-	if err != nil {
-		slog.Error("unable to start temporal build Worker: " + err.Error())
-	}
+	return server.Listen(fmt.Sprintf(":%d", port))
 }
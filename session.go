@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/url"
+	"pkbldr/auth"
+	"pkbldr/events"
+	"pkbldr/templates"
+	"pkbldr/templates/pages"
+	pages_login "pkbldr/templates/pages/login"
+	"strings"
+	"time"
+
+	"github.com/a-h/templ"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+)
+
+const sessionCookie = "pkbldr_session"
+
+// requireUser only lets requests with a valid session through. API requests
+// may pass the session token as a bearer token instead of the cookie.
+func requireUser(c *fiber.Ctx) error {
+	token := c.Cookies(sessionCookie)
+	if bearer, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer "); ok {
+		token = bearer
+	}
+	if token != "" {
+		if ok, username := auth.CheckSessionToken(token); ok {
+			c.Locals("username", username)
+			return c.Next()
+		}
+	}
+
+	if strings.HasPrefix(c.Path(), "/api/") {
+		return fiber.NewError(fiber.StatusUnauthorized, "login required")
+	}
+	return c.Redirect("/login?redirect=" + url.QueryEscape(c.OriginalURL()))
+}
+
+// currentActor returns the logged in user for the event log.
+func currentActor(c *fiber.Ctx) events.Actor {
+	username, _ := c.Locals("username").(string)
+	return events.UserActor(username)
+}
+
+func loginPageHandler(c *fiber.Ctx) error {
+	metaTags := pages.MetaTags(
+		"PikaOS, packages, package builder, build system", // define meta keywords
+		"Welcome to the PikaOS Package Builder",           // define meta description
+	)
+
+	bodyContent := pages_login.BodyContent(c.Query("redirect", "/"), c.Query("failed") != "")
+
+	templateHandler := templ.Handler(
+		templates.Layout(
+			"PikaOS Package Builder - Sign in", // define title text
+			metaTags, bodyContent, false,
+		),
+	)
+
+	return adaptor.HTTPHandler(templateHandler)(c)
+}
+
+func loginHandler(c *fiber.Ctx) error {
+	username := c.FormValue("username")
+	redirect := localRedirect(c.FormValue("redirect", "/"))
+
+	ok, err := auth.VerifyPassword(c.FormValue("password"), username)
+	if err != nil || !ok {
+		return c.Redirect("/login?failed=1&redirect=" + url.QueryEscape(redirect))
+	}
+
+	token, err := auth.GenerateAndStoreSessionToken(username)
+	if err != nil {
+		return err
+	}
+	c.Cookie(&fiber.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Expires:  time.Now().Add(time.Hour),
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteStrictMode,
+	})
+	return c.Redirect(redirect)
+}
+
+// localRedirect only lets redirects inside the builder through. Browsers
+// treat "//" and "/\" as the start of another host.
+func localRedirect(redirect string) string {
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || strings.HasPrefix(redirect, "/\\") {
+		return "/"
+	}
+	return redirect
+}
+
+// apiLoginHandler hands out a session token for API clients.
+func apiLoginHandler(c *fiber.Ctx) error {
+	var credentials struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	err := c.BodyParser(&credentials)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	ok, err := auth.VerifyPassword(credentials.Password, credentials.Username)
+	if err != nil || !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "invalid username or password")
+	}
+
+	token, err := auth.GenerateAndStoreSessionToken(credentials.Username)
+	if err != nil {
+		return err
+	}
+	return c.JSON(fiber.Map{"token": token})
+}
+
+func logoutHandler(c *fiber.Ctx) error {
+	auth.RemoveSessionToken(c.Cookies(sessionCookie))
+	c.ClearCookie(sessionCookie)
+	return c.Redirect("/")
+}
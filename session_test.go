@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestLocalRedirect(t *testing.T) {
+	tests := []struct {
+		redirect string
+		want     string
+	}{
+		{"/packages", "/packages"},
+		{"/packages?status=Error&limit=10", "/packages?status=Error&limit=10"},
+		{"", "/"},
+		{"https://example.com", "/"},
+		{"//example.com", "/"},
+		{"/\\example.com", "/"},
+	}
+	for _, tt := range tests {
+		if got := localRedirect(tt.redirect); got != tt.want {
+			t.Errorf("localRedirect(%q) = %q, want %q", tt.redirect, got, tt.want)
+		}
+	}
+}
+
+func TestRequireUserKeepsTheQuery(t *testing.T) {
+	app := fiber.New()
+	app.Get("/promotions", requireUser, func(c *fiber.Ctx) error { return nil })
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/promotions?source=hello&version=1.0", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "/login?redirect=%2Fpromotions%3Fsource%3Dhello%26version%3D1.0"
+	if got := resp.Header.Get(fiber.HeaderLocation); got != want {
+		t.Errorf("redirected to %q, want %q", got, want)
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"pkbldr/workflows"
-	"time"
 
 	"go.temporal.io/sdk/client"
 )
@@ -16,36 +15,10 @@ func BuildPackagesNow(c client.Client, ctx context.Context) {
 	}
 
 	// Start the Workflow
-	_, err := c.ExecuteWorkflow(ctx, options, workflows.BuildPackages)
+	_, err := c.ExecuteWorkflow(ctx, options, workflows.BuildPackages, workflows.BuildRunState{})
 	if err != nil {
 		fmt.Println("unable to complete startup package build Workflow", err)
 	} else {
 		fmt.Println("startup package build Workflow completed")
 	}
 }
-
-func ScheduleBuildPackages(c client.Client, ctx context.Context) {
-	scheduleID := "package-build-schedule"
-	workflowID := "scheduled-package-build-workflow"
-	_, err := c.ScheduleClient().Create(ctx, client.ScheduleOptions{
-		ID: scheduleID,
-		Spec: client.ScheduleSpec{
-			Jitter: 1 * time.Minute,
-			Intervals: []client.ScheduleIntervalSpec{
-				{
-					Every: 6 * time.Hour,
-				},
-			},
-		},
-		Action: &client.ScheduleWorkflowAction{
-			ID:        workflowID,
-			Workflow:  workflows.BuildPackages,
-			TaskQueue: workflows.PACKAGE_BUILD_TASK_QUEUE,
-		},
-	})
-	if err != nil {
-		fmt.Println("unable to create package build schedule", err)
-	} else {
-		fmt.Println("package build schedule created")
-	}
-}
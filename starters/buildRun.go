@@ -0,0 +1,61 @@
+package starters
+
+import (
+	"context"
+	"pkbldr/activities"
+	"pkbldr/workflows"
+
+	"go.temporal.io/sdk/client"
+)
+
+var ErrNoBuildRun = activities.ErrNoBuildRun
+
+// BuildRun is the state of the running package build workflow.
+type BuildRun struct {
+	WorkflowID string                      `json:"workflowid"`
+	RunID      string                      `json:"runid"`
+	Paused     bool                        `json:"paused"`
+	Queue      []string                    `json:"queue"`
+	InProgress []workflows.InProgressBuild `json:"inprogress"`
+	Completed  []workflows.BuildResult     `json:"completed"`
+}
+
+// GetBuildRun queries the running package build workflow for its state.
+func GetBuildRun(ctx context.Context, c client.Client) (BuildRun, error) {
+	workflowID, runID, err := activities.OpenBuildRun(ctx, c)
+	if err != nil {
+		return BuildRun{}, err
+	}
+	run := BuildRun{WorkflowID: workflowID, RunID: runID}
+
+	queries := []struct {
+		name   string
+		result interface{}
+	}{
+		{workflows.QueryPaused, &run.Paused},
+		{workflows.QueryQueue, &run.Queue},
+		{workflows.QueryInProgress, &run.InProgress},
+		{workflows.QueryCompleted, &run.Completed},
+	}
+	for _, query := range queries {
+		value, err := c.QueryWorkflow(ctx, workflowID, runID, query.name)
+		if err != nil {
+			return run, err
+		}
+		err = value.Get(query.result)
+		if err != nil {
+			return run, err
+		}
+	}
+	return run, nil
+}
+
+// SignalBuildRun sends a signal to the running package build workflow.
+func SignalBuildRun(ctx context.Context, c client.Client, signal string, arg interface{}) error {
+	workflowID, _, err := activities.OpenBuildRun(ctx, c)
+	if err != nil {
+		return err
+	}
+	// No run ID, the run may have continued as new since
+	return c.SignalWorkflow(ctx, workflowID, "", signal, arg)
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"pkbldr/workflows"
-	"time"
 
 	"go.temporal.io/sdk/client"
 )
@@ -23,29 +22,3 @@ func FetchPackagesNow(c client.Client, ctx context.Context) {
 		fmt.Println("startup package fetch Workflow completed")
 	}
 }
-
-func ScheduleFetchPackages(c client.Client, ctx context.Context) {
-	scheduleID := "package-fetch-schedule"
-	workflowID := "scheduled-package-fetch-workflow"
-	_, err := c.ScheduleClient().Create(ctx, client.ScheduleOptions{
-		ID: scheduleID,
-		Spec: client.ScheduleSpec{
-			Jitter: 1 * time.Minute,
-			Intervals: []client.ScheduleIntervalSpec{
-				{
-					Every: 1 * time.Hour,
-				},
-			},
-		},
-		Action: &client.ScheduleWorkflowAction{
-			ID:        workflowID,
-			Workflow:  workflows.FetchPackages,
-			TaskQueue: workflows.PACKAGE_FETCH_TASK_QUEUE,
-		},
-	})
-	if err != nil {
-		fmt.Println("unable to create package fetch schedule", err)
-	} else {
-		fmt.Println("package fetch schedule created")
-	}
-}
@@ -0,0 +1,56 @@
+package starters
+
+import (
+	"context"
+	"errors"
+	"pkbldr/activities"
+	"pkbldr/workflows"
+	"strconv"
+	"time"
+
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+)
+
+// PromoteBuild promotes a staged build on a build worker and waits for it.
+func PromoteBuild(ctx context.Context, c client.Client, request activities.PromoteRequest) error {
+	options := client.StartWorkflowOptions{
+		ID:        "promote-" + request.Source + "-" + request.Version,
+		TaskQueue: workflows.PACKAGE_BUILD_TASK_QUEUE,
+	}
+	run, err := c.ExecuteWorkflow(ctx, options, workflows.PromoteBuild, request)
+	if err != nil {
+		return err
+	}
+	return unwrapApplicationError(run.Get(ctx, nil))
+}
+
+// ReadBuildLog returns the tail of the newest build log of a package, read by
+// a build worker. It returns activities.ErrNoBuildLog when there is none.
+func ReadBuildLog(ctx context.Context, c client.Client, name string, lines int) (activities.BuildLog, error) {
+	options := client.StartWorkflowOptions{
+		ID:        "read-build-log-" + name + "-" + strconv.FormatInt(time.Now().UnixNano(), 10),
+		TaskQueue: workflows.PACKAGE_BUILD_TASK_QUEUE,
+	}
+	var log activities.BuildLog
+	run, err := c.ExecuteWorkflow(ctx, options, workflows.ReadBuildLog, name, lines)
+	if err != nil {
+		return log, err
+	}
+	err = run.Get(ctx, &log)
+	var appErr *temporal.ApplicationError
+	if errors.As(err, &appErr) && appErr.Type() == activities.NoBuildLogError {
+		return log, activities.ErrNoBuildLog
+	}
+	return log, unwrapApplicationError(err)
+}
+
+// unwrapApplicationError returns the error raised by an activity without the
+// workflow and activity wrappers around it.
+func unwrapApplicationError(err error) error {
+	var appErr *temporal.ApplicationError
+	if errors.As(err, &appErr) {
+		return errors.New(appErr.Error())
+	}
+	return err
+}
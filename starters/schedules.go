@@ -0,0 +1,224 @@
+package starters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"pkbldr/config"
+	"pkbldr/events"
+	"pkbldr/workflows"
+	"time"
+
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+)
+
+// Note of schedules paused by the configuration, tells them apart from
+// schedules paused by hand
+const configPauseNote = "paused in configuration"
+
+var ErrUnknownSchedule = errors.New("unknown schedule")
+
+// Schedule is a recurring workflow kept in Temporal.
+type Schedule struct {
+	Name       string
+	ID         string
+	WorkflowID string
+	Workflow   interface{}
+	Args       []interface{}
+	TaskQueue  string
+}
+
+var Schedules = []Schedule{
+	{
+		Name:       config.ScheduleFetch,
+		ID:         "package-fetch-schedule",
+		WorkflowID: "scheduled-package-fetch-workflow",
+		Workflow:   workflows.FetchPackages,
+		TaskQueue:  workflows.PACKAGE_FETCH_TASK_QUEUE,
+	},
+	{
+		Name:       config.ScheduleBuild,
+		ID:         "package-build-schedule",
+		WorkflowID: "scheduled-package-build-workflow",
+		Workflow:   workflows.BuildPackages,
+		Args:       []interface{}{workflows.BuildRunState{}},
+		TaskQueue:  workflows.PACKAGE_BUILD_TASK_QUEUE,
+	},
+}
+
+// ScheduleStatus is the state of a schedule in Temporal.
+type ScheduleStatus struct {
+	Name     string      `json:"name"`
+	Spec     string      `json:"spec"`
+	Paused   bool        `json:"paused"`
+	Note     string      `json:"note"`
+	LastRun  time.Time   `json:"lastrun"`
+	NextRuns []time.Time `json:"nextruns"`
+}
+
+func findSchedule(name string) (Schedule, error) {
+	for _, schedule := range Schedules {
+		if schedule.Name == name {
+			return schedule, nil
+		}
+	}
+	return Schedule{}, fmt.Errorf("%s: %w", name, ErrUnknownSchedule)
+}
+
+func scheduleSpec(schedule config.ScheduleConfig) (client.ScheduleSpec, error) {
+	spec := client.ScheduleSpec{CronExpressions: schedule.Cron}
+	if schedule.Jitter != "" {
+		jitter, err := time.ParseDuration(schedule.Jitter)
+		if err != nil {
+			return spec, fmt.Errorf("invalid jitter: %w", err)
+		}
+		spec.Jitter = jitter
+	}
+	if len(schedule.Cron) > 0 {
+		return spec, nil
+	}
+	interval, err := time.ParseDuration(schedule.Interval)
+	if err != nil {
+		return spec, fmt.Errorf("invalid interval: %w", err)
+	}
+	spec.Intervals = []client.ScheduleIntervalSpec{{Every: interval}}
+	return spec, nil
+}
+
+// SyncSchedules creates the configured schedules and updates existing ones to
+// match the configuration.
+func SyncSchedules(c client.Client, ctx context.Context) error {
+	var errs []error
+	for _, schedule := range Schedules {
+		err := syncSchedule(c, ctx, schedule)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s schedule: %w", schedule.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func syncSchedule(c client.Client, ctx context.Context, schedule Schedule) error {
+	cfg := config.Configs.Schedule(schedule.Name)
+	spec, err := scheduleSpec(cfg)
+	if err != nil {
+		return err
+	}
+	action := &client.ScheduleWorkflowAction{
+		ID:        schedule.WorkflowID,
+		Workflow:  schedule.Workflow,
+		Args:      schedule.Args,
+		TaskQueue: schedule.TaskQueue,
+	}
+
+	handle := c.ScheduleClient().GetHandle(ctx, schedule.ID)
+	description, err := handle.Describe(ctx)
+	var notFound *serviceerror.NotFound
+	if errors.As(err, &notFound) {
+		options := client.ScheduleOptions{
+			ID:     schedule.ID,
+			Spec:   spec,
+			Action: action,
+			Paused: cfg.Paused,
+		}
+		if cfg.Paused {
+			options.Note = configPauseNote
+		}
+		_, err = c.ScheduleClient().Create(ctx, options)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	err = handle.Update(ctx, client.ScheduleUpdateOptions{
+		DoUpdate: func(input client.ScheduleUpdateInput) (*client.ScheduleUpdate, error) {
+			updated := input.Description.Schedule
+			updated.Spec = &spec
+			updated.Action = action
+			return &client.ScheduleUpdate{Schedule: &updated}, nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Schedules paused by hand stay paused
+	state := description.Schedule.State
+	if cfg.Paused && !state.Paused {
+		return handle.Pause(ctx, client.SchedulePauseOptions{Note: configPauseNote})
+	}
+	if !cfg.Paused && state.Paused && state.Note == configPauseNote {
+		return handle.Unpause(ctx, client.ScheduleUnpauseOptions{Note: "unpaused in configuration"})
+	}
+	return nil
+}
+
+// ListSchedules returns the state of every schedule.
+func ListSchedules(c client.Client, ctx context.Context) ([]ScheduleStatus, error) {
+	statuses := make([]ScheduleStatus, 0, len(Schedules))
+	for _, schedule := range Schedules {
+		description, err := c.ScheduleClient().GetHandle(ctx, schedule.ID).Describe(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%s schedule: %w", schedule.Name, err)
+		}
+		status := ScheduleStatus{
+			Name:     schedule.Name,
+			Spec:     config.Configs.Schedule(schedule.Name).String(),
+			Paused:   description.Schedule.State.Paused,
+			Note:     description.Schedule.State.Note,
+			NextRuns: description.Info.NextActionTimes,
+		}
+		if recent := description.Info.RecentActions; len(recent) > 0 {
+			status.LastRun = recent[len(recent)-1].ActualTime
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// PauseSchedule stops a schedule from starting runs until it is unpaused.
+func PauseSchedule(c client.Client, ctx context.Context, name string, actor events.Actor) error {
+	schedule, err := findSchedule(name)
+	if err != nil {
+		return err
+	}
+	err = c.ScheduleClient().GetHandle(ctx, schedule.ID).Pause(ctx, client.SchedulePauseOptions{
+		Note: "paused by " + actor.String(),
+	})
+	if err != nil {
+		return err
+	}
+	events.RecordManualAction("", actor, "schedule", "paused "+name+" schedule")
+	return nil
+}
+
+func UnpauseSchedule(c client.Client, ctx context.Context, name string, actor events.Actor) error {
+	schedule, err := findSchedule(name)
+	if err != nil {
+		return err
+	}
+	err = c.ScheduleClient().GetHandle(ctx, schedule.ID).Unpause(ctx, client.ScheduleUnpauseOptions{
+		Note: "unpaused by " + actor.String(),
+	})
+	if err != nil {
+		return err
+	}
+	events.RecordManualAction("", actor, "schedule", "unpaused "+name+" schedule")
+	return nil
+}
+
+// TriggerSchedule starts a run of a schedule right away, paused or not.
+func TriggerSchedule(c client.Client, ctx context.Context, name string, actor events.Actor) error {
+	schedule, err := findSchedule(name)
+	if err != nil {
+		return err
+	}
+	err = c.ScheduleClient().GetHandle(ctx, schedule.ID).Trigger(ctx, client.ScheduleTriggerOptions{})
+	if err != nil {
+		return err
+	}
+	events.RecordManualAction("", actor, "schedule", "triggered "+name+" schedule")
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"pkbldr/activities"
+	"pkbldr/config"
+	"pkbldr/workflows"
+	"strings"
+	"sync"
+	"time"
+
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/worker"
+)
+
+// How long running activities get to wind down on shutdown. Builds are
+// cancelled right away and only need it to put their packages back.
+const workerStopTimeout = 30 * time.Second
+
+func newTemporalFetchWorker(c client.Client) worker.Worker {
+	// This worker hosts both Workflow and Activity functions
+	w := worker.New(c, workflows.PACKAGE_FETCH_TASK_QUEUE, worker.Options{
+		WorkerStopTimeout: workerStopTimeout,
+	})
+	w.RegisterWorkflow(workflows.FetchPackages)
+	w.RegisterActivity(activities.FetchPackages)
+	w.RegisterActivity(activities.PlanChainedBuild)
+	w.RegisterActivity(activities.ChainedBuildTarget)
+	return w
+}
+
+func newTemporalBuildWorker(c client.Client) worker.Worker {
+	// This worker hosts both Workflow and Activity functions
+	w := worker.New(c, workflows.PACKAGE_BUILD_TASK_QUEUE, worker.Options{
+		WorkerStopTimeout: workerStopTimeout,
+	})
+	w.RegisterWorkflow(workflows.BuildPackages)
+	w.RegisterWorkflow(workflows.BuildSourcePackage)
+	w.RegisterWorkflow(workflows.PromoteBuild)
+	w.RegisterWorkflow(workflows.ReadBuildLog)
+	w.RegisterActivity(activities.UpdateDockerContainer)
+	w.RegisterActivity(activities.QueueBuilds)
+	w.RegisterActivity(activities.CancelQueuedBuild)
+	w.RegisterActivity(activities.PublishRepository)
+	w.RegisterActivity(activities.PromoteBuild)
+	w.RegisterActivity(activities.TailBuildLog)
+	w.RegisterActivity(activities.FetchPackages)
+	return w
+}
+
+func newTemporalSourceBuildWorker(c client.Client) worker.Worker {
+	// Every build runs in a container of its own, the worker limits how many
+	// run at the same time
+	w := worker.New(c, workflows.SOURCE_BUILD_TASK_QUEUE, worker.Options{
+		MaxConcurrentActivityExecutionSize: config.Configs.Concurrency(),
+		WorkerStopTimeout:                  workerStopTimeout,
+	})
+	w.RegisterActivity(activities.BuildSource)
+	w.RegisterActivity(activities.CheckReproducible)
+	return w
+}
+
+// Task queues a worker process can serve
+const (
+	queueFetch  = "fetch"
+	queueBuild  = "build"
+	queueSource = "source"
+)
+
+var allQueues = []string{queueFetch, queueBuild, queueSource}
+
+// runWorkers runs the workers of the given task queues until ctx is
+// cancelled, then stops them and waits for their activities to wind down.
+func runWorkers(ctx context.Context, c client.Client, queues []string) error {
+	workers := make([]worker.Worker, 0, len(queues))
+	for _, queue := range queues {
+		switch queue {
+		case queueFetch:
+			workers = append(workers, newTemporalFetchWorker(c))
+		case queueBuild:
+			workers = append(workers, newTemporalBuildWorker(c))
+		case queueSource:
+			workers = append(workers, newTemporalSourceBuildWorker(c))
+		default:
+			return fmt.Errorf("unknown queue %s, valid queues are %s", queue, strings.Join(allQueues, ","))
+		}
+	}
+
+	started := make([]worker.Worker, 0, len(workers))
+	defer func() {
+		var wg sync.WaitGroup
+		for _, w := range started {
+			wg.Add(1)
+			go func(w worker.Worker) {
+				defer wg.Done()
+				w.Stop()
+			}(w)
+		}
+		wg.Wait()
+	}()
+	for i, w := range workers {
+		// Start listening to the Task Queue
+		err := w.Start()
+		if err != nil {
+			return fmt.Errorf("unable to start temporal %s worker: %w", queues[i], err)
+		}
+		started = append(started, w)
+	}
+
+	<-ctx.Done()
+	fmt.Println("stopping workers")
+	return nil
+}
@@ -1,30 +1,346 @@
 package workflows
 
 import (
+	"slices"
+	"sort"
 	"time"
 
 	"pkbldr/activities"
+	"pkbldr/events"
 
+	"go.temporal.io/sdk/temporal"
 	"go.temporal.io/sdk/workflow"
 )
 
 const PACKAGE_BUILD_TASK_QUEUE = "PACKAGE_BUILD_TASK_QUEUE"
 
-func BuildPackages(ctx workflow.Context) error {
+// Task queue of the single source builds, its worker limits how many run at
+// once
+const SOURCE_BUILD_TASK_QUEUE = "SOURCE_BUILD_TASK_QUEUE"
+
+// Signals controlling a running build run
+const (
+	SignalPause  = "pause"
+	SignalResume = "resume"
+	// Payload is a SourceSignal
+	SignalCancel = "cancel"
+	// Payload is a SourceSignal, moves the source to the front of the queue
+	SignalPrioritize = "prioritize"
+	// Queues the sources that became buildable since the run started
+	SignalEnqueue = "enqueue"
+)
+
+// Queries answered by a running build run
+const (
+	QueryQueue      = "queue"
+	QueryInProgress = "inprogress"
+	QueryCompleted  = "completed"
+	QueryPaused     = "paused"
+)
+
+// SourceSignal is the payload of the signals aimed at a single source.
+type SourceSignal struct {
+	Source string `json:"source"`
+	// Who sent the signal
+	Actor events.Actor `json:"actor"`
+}
+
+// InProgressBuild is a source currently being built.
+type InProgressBuild struct {
+	Source  string    `json:"source"`
+	Started time.Time `json:"started"`
+}
+
+type BuildOutcome string
+
+const (
+	BuildSucceeded BuildOutcome = "succeeded"
+	BuildFailed    BuildOutcome = "failed"
+	BuildCancelled BuildOutcome = "cancelled"
+)
+
+// BuildResult is a source whose build is over.
+type BuildResult struct {
+	Source   string        `json:"source"`
+	Outcome  BuildOutcome  `json:"outcome"`
+	Error    string        `json:"error"`
+	Duration time.Duration `json:"duration"`
+}
+
+// A build run hands over to a fresh run after starting this many builds,
+// keeping its history within Temporal's limits
+var buildsPerRun = 500
+
+// Results of earlier builds a continued run keeps answering queries with
+const carriedResults = 200
+
+// BuildRunState is what a build run hands over when it continues as new.
+type BuildRunState struct {
+	// Zero for a run started by the schedule or a fetch
+	Continued   bool          `json:"continued"`
+	Queue       []string      `json:"queue"`
+	Paused      bool          `json:"paused"`
+	Concurrency int           `json:"concurrency"`
+	Completed   []BuildResult `json:"completed"`
+}
+
+type runningBuild struct {
+	future  workflow.ChildWorkflowFuture
+	cancel  workflow.CancelFunc
+	started time.Time
+}
+
+func BuildPackages(ctx workflow.Context, state BuildRunState) error {
 	options := workflow.ActivityOptions{
 		StartToCloseTimeout: time.Hour * 720,
 	}
-	ctx = workflow.WithActivityOptions(ctx, options)
+	updateCtx := workflow.WithActivityOptions(ctx, options)
 
-	err := workflow.ExecuteActivity(ctx, activities.UpdateDockerContainer, nil).Get(ctx, nil)
+	if !state.Continued {
+		err := workflow.ExecuteActivity(updateCtx, activities.UpdateDockerContainer).Get(updateCtx, nil)
+		if err != nil {
+			return err
+		}
+
+		var plan activities.BuildPlan
+		err = workflow.ExecuteActivity(updateCtx, activities.QueueBuilds).Get(updateCtx, &plan)
+		if err != nil {
+			return err
+		}
+		state.Queue = plan.Sources
+		state.Concurrency = plan.Concurrency
+	}
+
+	queue := state.Queue
+	paused := state.Paused
+	running := make(map[string]*runningBuild)
+	completed := append(make([]BuildResult, 0), state.Completed...)
+	// Children share the run ID so overlapping runs never collide
+	runID := workflow.GetInfo(ctx).WorkflowExecution.RunID
+	started := 0
+
+	err := workflow.SetQueryHandler(ctx, QueryQueue, func() ([]string, error) {
+		return queue, nil
+	})
+	if err != nil {
+		return err
+	}
+	err = workflow.SetQueryHandler(ctx, QueryInProgress, func() ([]InProgressBuild, error) {
+		inProgress := make([]InProgressBuild, 0, len(running))
+		for source, build := range running {
+			inProgress = append(inProgress, InProgressBuild{Source: source, Started: build.started})
+		}
+		return inProgress, nil
+	})
+	if err != nil {
+		return err
+	}
+	err = workflow.SetQueryHandler(ctx, QueryCompleted, func() ([]BuildResult, error) {
+		return completed, nil
+	})
+	if err != nil {
+		return err
+	}
+	err = workflow.SetQueryHandler(ctx, QueryPaused, func() (bool, error) {
+		return paused, nil
+	})
 	if err != nil {
 		return err
 	}
 
-	err = workflow.ExecuteActivity(ctx, activities.StartBuildLoop, nil).Get(ctx, nil)
+	pauseCh := workflow.GetSignalChannel(ctx, SignalPause)
+	resumeCh := workflow.GetSignalChannel(ctx, SignalResume)
+	cancelCh := workflow.GetSignalChannel(ctx, SignalCancel)
+	prioritizeCh := workflow.GetSignalChannel(ctx, SignalPrioritize)
+	enqueueCh := workflow.GetSignalChannel(ctx, SignalEnqueue)
+
+	enqueue := func() {
+		var added activities.BuildPlan
+		err := workflow.ExecuteActivity(updateCtx, activities.QueueBuilds).Get(updateCtx, &added)
+		if err != nil {
+			workflow.GetLogger(ctx).Warn("unable to queue new builds", "error", err)
+			return
+		}
+		for _, source := range added.Sources {
+			if !slices.Contains(queue, source) {
+				queue = append(queue, source)
+			}
+		}
+	}
+
+	for {
+		// Work handed over while the last builds finished still belongs to
+		// this run
+		for enqueueCh.ReceiveAsync(nil) {
+			enqueue()
+		}
+		if len(queue) == 0 && len(running) == 0 {
+			break
+		}
+
+		// Keep as many builds running as the workers allow
+		handOver := started >= buildsPerRun
+		for !paused && !handOver && len(running) < state.Concurrency {
+			// A source queued again while it builds waits for that build
+			next := slices.IndexFunc(queue, func(source string) bool {
+				_, ok := running[source]
+				return !ok
+			})
+			if next < 0 {
+				break
+			}
+			source := queue[next]
+			queue = slices.Delete(queue, next, next+1)
+			childCtx, cancel := workflow.WithCancel(ctx)
+			childCtx = workflow.WithChildOptions(childCtx, workflow.ChildWorkflowOptions{
+				WorkflowID: runID + "-build-" + source,
+			})
+			running[source] = &runningBuild{
+				future:  workflow.ExecuteChildWorkflow(childCtx, BuildSourcePackage, source),
+				cancel:  cancel,
+				started: workflow.Now(ctx),
+			}
+			started++
+		}
+
+		selector := workflow.NewSelector(ctx)
+		selector.AddReceive(pauseCh, func(c workflow.ReceiveChannel, more bool) {
+			c.Receive(ctx, nil)
+			paused = true
+		})
+		selector.AddReceive(resumeCh, func(c workflow.ReceiveChannel, more bool) {
+			c.Receive(ctx, nil)
+			paused = false
+		})
+		selector.AddReceive(cancelCh, func(c workflow.ReceiveChannel, more bool) {
+			var signal SourceSignal
+			c.Receive(ctx, &signal)
+			if build, ok := running[signal.Source]; ok {
+				// The child resets the packages once its build stopped
+				build.cancel()
+				return
+			}
+			if removeSource(&queue, signal.Source) {
+				err := workflow.ExecuteActivity(updateCtx, activities.CancelQueuedBuild, signal.Source, signal.Actor).Get(updateCtx, nil)
+				if err != nil {
+					workflow.GetLogger(ctx).Warn("unable to cancel queued build", "source", signal.Source, "error", err)
+				}
+				completed = append(completed, BuildResult{Source: signal.Source, Outcome: BuildCancelled})
+			}
+		})
+		selector.AddReceive(enqueueCh, func(c workflow.ReceiveChannel, more bool) {
+			c.Receive(ctx, nil)
+			enqueue()
+		})
+		selector.AddReceive(prioritizeCh, func(c workflow.ReceiveChannel, more bool) {
+			var signal SourceSignal
+			c.Receive(ctx, &signal)
+			if removeSource(&queue, signal.Source) {
+				queue = append([]string{signal.Source}, queue...)
+			}
+		})
+		// Map order is random, the selector needs a deterministic one
+		sources := make([]string, 0, len(running))
+		for source := range running {
+			sources = append(sources, source)
+		}
+		sort.Strings(sources)
+		for _, source := range sources {
+			source, build := source, running[source]
+			selector.AddFuture(build.future, func(f workflow.Future) {
+				result := BuildResult{
+					Source:   source,
+					Outcome:  BuildSucceeded,
+					Duration: workflow.Now(ctx).Sub(build.started),
+				}
+				err := f.Get(ctx, nil)
+				if temporal.IsCanceledError(err) {
+					result.Outcome = BuildCancelled
+				} else if err != nil {
+					result.Outcome = BuildFailed
+					result.Error = err.Error()
+				}
+				completed = append(completed, result)
+				delete(running, source)
+			})
+		}
+		if handOver && len(running) == 0 {
+			// Signals received so far belong to the handed over state
+			for selector.HasPending() {
+				selector.Select(ctx)
+			}
+			if len(completed) > carriedResults {
+				completed = completed[len(completed)-carriedResults:]
+			}
+			return workflow.NewContinueAsNewError(ctx, BuildPackages, BuildRunState{
+				Continued:   true,
+				Queue:       queue,
+				Paused:      paused,
+				Concurrency: state.Concurrency,
+				Completed:   completed,
+			})
+		}
+		selector.Select(ctx)
+	}
+
+	failed := 0
+	for _, result := range completed {
+		if result.Outcome == BuildFailed {
+			failed++
+		}
+	}
+	workflow.GetLogger(ctx).Info("builds finished", "sources", len(completed), "failed", failed)
+
+	return workflow.ExecuteActivity(updateCtx, activities.PublishRepository).Get(updateCtx, nil)
+}
+
+// removeSource takes a source out of the queue, reporting whether it was
+// queued.
+func removeSource(queue *[]string, source string) bool {
+	for i, queued := range *queue {
+		if queued == source {
+			*queue = append((*queue)[:i], (*queue)[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// BuildSourcePackage builds the packages of a single source.
+func BuildSourcePackage(ctx workflow.Context, source string) error {
+	options := workflow.ActivityOptions{
+		TaskQueue:           SOURCE_BUILD_TASK_QUEUE,
+		StartToCloseTimeout: time.Hour * 72,
+		// The build heartbeats, a dead worker is noticed within minutes
+		HeartbeatTimeout: time.Minute * 5,
+		// Let the build put its packages back before the child ends
+		WaitForCancellation: true,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:        time.Minute,
+			BackoffCoefficient:     2,
+			MaximumAttempts:        3,
+			NonRetryableErrorTypes: []string{"BuildFailed"},
+		},
+	}
+	buildCtx := workflow.WithActivityOptions(ctx, options)
+	err := workflow.ExecuteActivity(buildCtx, activities.BuildSource, source).Get(buildCtx, nil)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	// The second build of the reproducibility check goes back to the pool
+	// and may run on another worker
+	reproOptions := options
+	reproOptions.RetryPolicy = &temporal.RetryPolicy{
+		InitialInterval: time.Minute,
+		MaximumAttempts: 2,
+	}
+	reproCtx := workflow.WithActivityOptions(ctx, reproOptions)
+	err = workflow.ExecuteActivity(reproCtx, activities.CheckReproducible, source).Get(reproCtx, nil)
+	if err != nil && !temporal.IsCanceledError(err) {
+		// The build itself succeeded
+		workflow.GetLogger(ctx).Error("reproducibility check failed", "source", source, "error", err)
+		return nil
+	}
+	return err
 }
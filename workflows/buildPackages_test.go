@@ -0,0 +1,83 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+)
+
+// newBuildRunEnv returns a test environment whose source builds only record
+// the IDs they ran under.
+func newBuildRunEnv(built *[]string) *testsuite.TestWorkflowEnvironment {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(BuildPackages)
+	env.RegisterWorkflowWithOptions(func(ctx workflow.Context, source string) error {
+		*built = append(*built, workflow.GetInfo(ctx).WorkflowExecution.ID)
+		return nil
+	}, workflow.RegisterOptions{Name: "BuildSourcePackage"})
+	env.RegisterActivityWithOptions(func(ctx context.Context) error {
+		return nil
+	}, activity.RegisterOptions{Name: "PublishRepository"})
+	return env
+}
+
+func TestBuildPackagesContinuesAsNew(t *testing.T) {
+	defer func(n int) { buildsPerRun = n }(buildsPerRun)
+	buildsPerRun = 2
+
+	var built []string
+	env := newBuildRunEnv(&built)
+	env.ExecuteWorkflow(BuildPackages, BuildRunState{
+		Continued:   true,
+		Queue:       []string{"a", "b", "c", "d"},
+		Concurrency: 1,
+	})
+
+	var continued *workflow.ContinueAsNewError
+	if !errors.As(env.GetWorkflowError(), &continued) {
+		t.Fatalf("workflow ended with %v, want a continue as new", env.GetWorkflowError())
+	}
+	var state BuildRunState
+	err := converter.GetDefaultDataConverter().FromPayloads(continued.Input, &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.Continued || state.Concurrency != 1 || !slices.Equal(state.Queue, []string{"c", "d"}) || len(state.Completed) != 2 {
+		t.Errorf("handed over %+v", state)
+	}
+	if len(built) != 2 {
+		t.Errorf("built %v before handing over, want 2 builds", built)
+	}
+}
+
+func TestBuildPackagesChildIDs(t *testing.T) {
+	var built []string
+	env := newBuildRunEnv(&built)
+	env.ExecuteWorkflow(BuildPackages, BuildRunState{
+		Continued:   true,
+		Queue:       []string{"a", "b"},
+		Concurrency: 2,
+	})
+
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatal(err)
+	}
+	if len(built) != 2 {
+		t.Fatalf("built %v, want 2 builds", built)
+	}
+	for _, id := range built {
+		// Prefixed by the run ID, a plain "build-<source>" collides with
+		// builds of overlapping runs
+		if strings.HasPrefix(id, "build-") || !strings.Contains(id, "-build-") {
+			t.Errorf("child workflow ID %q is not scoped to its run", id)
+		}
+	}
+}
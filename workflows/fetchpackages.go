@@ -4,18 +4,65 @@ import (
 	"time"
 
 	"pkbldr/activities"
+	"pkbldr/packages"
 
+	enumspb "go.temporal.io/api/enums/v1"
 	"go.temporal.io/sdk/workflow"
 )
 
 const PACKAGE_FETCH_TASK_QUEUE = "PACKAGE_FETCH_TASK_QUEUE"
 
-func FetchPackages(ctx workflow.Context) error {
+func FetchPackages(ctx workflow.Context) (packages.FetchSummary, error) {
 	options := workflow.ActivityOptions{
 		StartToCloseTimeout: time.Hour * 1,
 	}
 	ctx = workflow.WithActivityOptions(ctx, options)
-	err := workflow.ExecuteActivity(ctx, activities.FetchPackages, nil).Get(ctx, nil)
+	var summary packages.FetchSummary
+	err := workflow.ExecuteActivity(ctx, activities.FetchPackages).Get(ctx, &summary)
+	if err != nil {
+		return summary, err
+	}
+
+	err = chainBuild(ctx, summary)
+	if err != nil {
+		workflow.GetLogger(ctx).Error("unable to trigger builds", "error", err)
+	}
+	return summary, nil
+}
+
+// chainBuild hands the new work of a fetch to the open build run, or starts
+// one when there is none.
+func chainBuild(ctx workflow.Context, summary packages.FetchSummary) error {
+	var plan activities.ChainPlan
+	err := workflow.ExecuteActivity(ctx, activities.PlanChainedBuild, summary).Get(ctx, &plan)
+	if err != nil || !plan.Trigger {
+		return err
+	}
+	if plan.Debounce > 0 {
+		err = workflow.Sleep(ctx, plan.Debounce)
+		if err != nil {
+			return err
+		}
+	}
+
+	var target activities.ChainTarget
+	err = workflow.ExecuteActivity(ctx, activities.ChainedBuildTarget).Get(ctx, &target)
+	if err != nil {
+		return err
+	}
+	if target.WorkflowID != "" {
+		// No run ID, the run may have continued as new since
+		return workflow.SignalExternalWorkflow(ctx, target.WorkflowID, "", SignalEnqueue, nil).Get(ctx, nil)
+	}
+	if !target.Start {
+		return nil
+	}
 
-	return err
+	childCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+		WorkflowID: "chained-package-build-workflow-" + workflow.Now(ctx).UTC().Format("20060102-150405"),
+		TaskQueue:  PACKAGE_BUILD_TASK_QUEUE,
+		// The build run outlives the fetch
+		ParentClosePolicy: enumspb.PARENT_CLOSE_POLICY_ABANDON,
+	})
+	return workflow.ExecuteChildWorkflow(childCtx, BuildPackages, BuildRunState{}).GetChildWorkflowExecution().Get(ctx, nil)
 }
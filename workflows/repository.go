@@ -0,0 +1,43 @@
+package workflows
+
+import (
+	"time"
+
+	"pkbldr/activities"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// The build workers share the repository and the build logs with the source
+// workers, the server may run on another machine. These workflows let the
+// server reach them.
+
+// PromoteBuild promotes a staged build on a build worker.
+func PromoteBuild(ctx workflow.Context, request activities.PromoteRequest) error {
+	options := workflow.ActivityOptions{
+		TaskQueue:           PACKAGE_BUILD_TASK_QUEUE,
+		StartToCloseTimeout: time.Minute * 30,
+		RetryPolicy: &temporal.RetryPolicy{
+			MaximumAttempts: 3,
+		},
+	}
+	ctx = workflow.WithActivityOptions(ctx, options)
+	return workflow.ExecuteActivity(ctx, activities.PromoteBuild, request).Get(ctx, nil)
+}
+
+// ReadBuildLog returns the tail of the newest build log of a package from a
+// build worker.
+func ReadBuildLog(ctx workflow.Context, name string, lines int) (activities.BuildLog, error) {
+	options := workflow.ActivityOptions{
+		TaskQueue:           PACKAGE_BUILD_TASK_QUEUE,
+		StartToCloseTimeout: time.Minute,
+		RetryPolicy: &temporal.RetryPolicy{
+			MaximumAttempts: 3,
+		},
+	}
+	ctx = workflow.WithActivityOptions(ctx, options)
+	var log activities.BuildLog
+	err := workflow.ExecuteActivity(ctx, activities.TailBuildLog, name, lines).Get(ctx, &log)
+	return log, err
+}